@@ -0,0 +1,215 @@
+package httpclient
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-resty/resty/v2"
+)
+
+// AdaptiveTimeoutOptions configures AdaptiveTimeouts, zero values fall back to defaults
+type AdaptiveTimeoutOptions struct {
+	// Multiplier is applied to the observed p99, defaults to 3
+	Multiplier float64
+	// Floor and Ceiling bound the timeout, default to 100ms and 30s
+	Floor   time.Duration
+	Ceiling time.Duration
+	// MinSamples is the number of latencies needed before adapting, the timeout is
+	// Ceiling until then. Defaults to 20.
+	MinSamples int
+	// ByRoute keys the latencies by host and URL template instead of host only
+	ByRoute bool
+}
+
+// TimeoutStats is a snapshot of the adaptive timeout of a host or route
+type TimeoutStats struct {
+	Timeout time.Duration `json:"timeout"`
+	P99     time.Duration `json:"p99"`
+	Samples int           `json:"samples"`
+}
+
+// AdaptiveTimeoutError is returned when the response headers did not arrive within
+// the adaptive timeout, it matches context.DeadlineExceeded
+type AdaptiveTimeoutError struct {
+	Key     string
+	Timeout time.Duration
+}
+
+func (e *AdaptiveTimeoutError) Error() string {
+	return fmt.Sprintf("httpclient: no response from %s within the adaptive timeout of %s", e.Key, e.Timeout)
+}
+
+func (e *AdaptiveTimeoutError) Unwrap() error {
+	return context.DeadlineExceeded
+}
+
+const adaptiveWindow = 500
+
+// AdaptiveTimeouts bounds the wait for the response headers of each host, or route,
+// to a multiple of its observed p99 latency. The latencies come from resty.TraceInfo;
+// a request timing out counts as a sample at the timeout, so a slow route gets more
+// time. Options.Timeout still bounds the whole request, body included.
+type AdaptiveTimeouts struct {
+	opts    AdaptiveTimeoutOptions
+	mu      sync.Mutex
+	samples map[string]*latencies
+}
+
+func NewAdaptiveTimeouts(opts AdaptiveTimeoutOptions) *AdaptiveTimeouts {
+	if opts.Multiplier <= 0 {
+		opts.Multiplier = 3
+	}
+	if opts.Floor <= 0 {
+		opts.Floor = 100 * time.Millisecond
+	}
+	if opts.Ceiling <= 0 {
+		opts.Ceiling = 30 * time.Second
+	}
+	if opts.Ceiling < opts.Floor {
+		opts.Ceiling = opts.Floor
+	}
+	if opts.MinSamples <= 0 {
+		opts.MinSamples = 20
+	}
+	return &AdaptiveTimeouts{
+		opts:    opts,
+		samples: map[string]*latencies{},
+	}
+}
+
+// Key returns the key of the latencies of a request to host, made from template
+// (see URLTemplate) when ByRoute is set
+func (a *AdaptiveTimeouts) Key(host, template string) string {
+	if !a.opts.ByRoute {
+		return host
+	}
+	if i := strings.Index(template, "://"); i >= 0 {
+		template = template[i+3:]
+		if j := strings.IndexByte(template, '/'); j >= 0 {
+			template = template[j:]
+		} else {
+			template = "/"
+		}
+	}
+	if i := strings.IndexByte(template, '?'); i >= 0 {
+		template = template[:i]
+	}
+	return host + template
+}
+
+// Observe stores the time to the response headers of a request to key
+func (a *AdaptiveTimeouts) Observe(key string, d time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	l, ok := a.samples[key]
+	if !ok {
+		l = &latencies{}
+		a.samples[key] = l
+	}
+	l.add(d, adaptiveWindow)
+}
+
+// timeout must be called with a.mu held
+func (a *AdaptiveTimeouts) timeout(l *latencies) (time.Duration, time.Duration) {
+	if l == nil || len(l.values) < a.opts.MinSamples {
+		return a.opts.Ceiling, 0
+	}
+	p99 := l.percentile(99)
+	timeout := time.Duration(float64(p99) * a.opts.Multiplier)
+	if timeout < a.opts.Floor {
+		timeout = a.opts.Floor
+	}
+	if timeout > a.opts.Ceiling {
+		timeout = a.opts.Ceiling
+	}
+	return timeout, p99
+}
+
+// Timeout returns the current timeout of key
+func (a *AdaptiveTimeouts) Timeout(key string) time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	timeout, _ := a.timeout(a.samples[key])
+	return timeout
+}
+
+// Timeouts returns a snapshot of every known host or route
+func (a *AdaptiveTimeouts) Timeouts() map[string]TimeoutStats {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	ret := make(map[string]TimeoutStats, len(a.samples))
+	for key, l := range a.samples {
+		timeout, p99 := a.timeout(l)
+		ret[key] = TimeoutStats{Timeout: timeout, P99: p99, Samples: len(l.values)}
+	}
+	return ret
+}
+
+// OnAfterResponse returns a middleware observing the time to the response headers
+// of every traced attempt
+func (a *AdaptiveTimeouts) OnAfterResponse() resty.ResponseMiddleware {
+	return func(c *resty.Client, r *resty.Response) error {
+		ti := r.Request.TraceInfo()
+		if ti.ServerTime <= 0 || r.RawResponse == nil || r.RawResponse.Request == nil {
+			// no connection was traced, as for cache hits
+			return nil
+		}
+		key := a.Key(r.RawResponse.Request.URL.Host, URLTemplate(r.Request))
+		a.Observe(key, ti.ConnTime+ti.ServerTime)
+		return nil
+	}
+}
+
+// AdaptiveTimeoutsMW returns the current timeouts as JSON, keyed by host or route
+func AdaptiveTimeoutsMW(timeouts *AdaptiveTimeouts) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, timeouts.Timeouts())
+	}
+}
+
+// AdaptiveTimeoutTransport defines a http.RoundTripper failing the requests whose
+// response headers do not arrive within their adaptive timeout
+type AdaptiveTimeoutTransport struct {
+	T        http.RoundTripper
+	Timeouts *AdaptiveTimeouts
+}
+
+func (at *AdaptiveTimeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	template, ok := req.Context().Value(urlTemplateKey{}).(string)
+	if !ok {
+		template = req.URL.Path
+	}
+	key := at.Timeouts.Key(req.URL.Host, template)
+	timeout := at.Timeouts.Timeout(key)
+
+	ctx, cancel := context.WithCancel(req.Context())
+	expired := int32(0)
+	timer := time.AfterFunc(timeout, func() {
+		atomic.StoreInt32(&expired, 1)
+		cancel()
+	})
+	resp, err := at.T.RoundTrip(req.WithContext(ctx))
+	if !timer.Stop() && atomic.LoadInt32(&expired) == 1 {
+		if err == nil {
+			resp.Body.Close()
+		}
+		at.Timeouts.Observe(key, timeout)
+		return nil, &AdaptiveTimeoutError{Key: key, Timeout: timeout}
+	}
+	if err != nil || resp.Body == nil {
+		cancel()
+		return resp, err
+	}
+	// the body is read after the timeout, under Options.Timeout only
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
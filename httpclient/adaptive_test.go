@@ -0,0 +1,119 @@
+package httpclient_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/SpazioDati/go-utils/httpclient"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdaptiveTimeouts(t *testing.T) {
+	assert := assert.New(t)
+	timeouts := NewAdaptiveTimeouts(AdaptiveTimeoutOptions{
+		Multiplier: 2,
+		Floor:      10 * time.Millisecond,
+		Ceiling:    time.Second,
+		MinSamples: 10,
+	})
+
+	for i := 1; i <= 9; i++ {
+		timeouts.Observe("foo", time.Duration(i)*time.Millisecond)
+	}
+	assert.Equal(time.Second, timeouts.Timeout("foo"), "not enough samples")
+
+	timeouts.Observe("foo", 10*time.Millisecond)
+	assert.Equal(20*time.Millisecond, timeouts.Timeout("foo"))
+	timeouts.Observe("foo", 2*time.Second)
+	assert.Equal(time.Second, timeouts.Timeout("foo"), "up to the ceiling")
+
+	for i := 0; i < 10; i++ {
+		timeouts.Observe("bar", time.Millisecond)
+	}
+	assert.Equal(10*time.Millisecond, timeouts.Timeout("bar"), "down to the floor")
+	assert.Equal(time.Second, timeouts.Timeout("baz"))
+
+	assert.Equal(map[string]TimeoutStats{
+		"foo": {Timeout: time.Second, P99: 2 * time.Second, Samples: 11},
+		"bar": {Timeout: 10 * time.Millisecond, P99: time.Millisecond, Samples: 10},
+	}, timeouts.Timeouts())
+
+	assert.Equal("example.com", timeouts.Key("example.com", "/users/{id}"))
+	byRoute := NewAdaptiveTimeouts(AdaptiveTimeoutOptions{ByRoute: true})
+	assert.Equal("example.com/users/{id}", byRoute.Key("example.com", "https://example.com/users/{id}?full=1"))
+	assert.Equal("example.com/", byRoute.Key("example.com", "https://example.com"))
+	assert.Equal("example.com/users", byRoute.Key("example.com", "/users"))
+}
+
+func TestAdaptiveTimeoutTransport(t *testing.T) {
+	assert := assert.New(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sleep, _ := time.ParseDuration(r.URL.Query().Get("sleep"))
+		if r.URL.Query().Get("body") != "" {
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+		}
+		select {
+		case <-time.After(sleep):
+		case <-r.Context().Done():
+			return
+		}
+		w.Write([]byte("done"))
+	}))
+	defer ts.Close()
+	host := strings.TrimPrefix(ts.URL, "http://")
+
+	timeouts := NewAdaptiveTimeouts(AdaptiveTimeoutOptions{
+		Floor:      50 * time.Millisecond,
+		MinSamples: 5,
+		ByRoute:    true,
+	})
+	client := New(Options{
+		HTTPClient:       &http.Client{},
+		Logger:           &logMock{},
+		DisableTracing:   true,
+		AdaptiveTimeouts: timeouts,
+	})
+	key := host + "/users/{id}"
+
+	for i := 0; i < 5; i++ {
+		resp, err := client.R().SetPathParam("id", "1").Get(ts.URL + "/users/{id}")
+		assert.Nil(err)
+		assert.Equal("done", resp.String())
+	}
+	stats := timeouts.Timeouts()[key]
+	assert.Equal(5, stats.Samples)
+	assert.Equal(50*time.Millisecond, stats.Timeout)
+
+	resp, err := client.R().SetPathParam("id", "2").SetQueryParam("sleep", "100ms").SetQueryParam("body", "1").
+		Get(ts.URL + "/users/{id}")
+	assert.Nil(err, "the body is not bound by the adaptive timeout")
+	assert.Equal("done", resp.String())
+
+	start := time.Now()
+	_, err = client.R().SetPathParam("id", "3").SetQueryParam("sleep", "1s").
+		Get(ts.URL + "/users/{id}")
+	assert.Less(int64(time.Since(start)), int64(500*time.Millisecond))
+	var timeoutErr *AdaptiveTimeoutError
+	assert.True(errors.As(err, &timeoutErr), "%v", err)
+	assert.Equal(key, timeoutErr.Key)
+	assert.Equal(50*time.Millisecond, timeoutErr.Timeout)
+	assert.True(errors.Is(err, context.DeadlineExceeded))
+	assert.Equal(7, timeouts.Timeouts()[key].Samples, "the timeout is a sample")
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/timeouts", AdaptiveTimeoutsMW(timeouts))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/timeouts", nil))
+	var body map[string]TimeoutStats
+	assert.Nil(json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(timeouts.Timeouts(), body)
+}
@@ -0,0 +1,141 @@
+package httpclient
+
+import (
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// Jitter selects how randomness is added to the exponential delay
+type Jitter int
+
+const (
+	// FullJitter sleeps a random duration between 0 and the exponential delay
+	FullJitter Jitter = iota
+	// DecorrelatedJitter sleeps a random duration between BaseDelay and
+	// Multiplier times the previous delay
+	DecorrelatedJitter
+	// NoJitter sleeps exactly the exponential delay
+	NoJitter
+)
+
+// BackoffPolicy defines the wait between retries, zero values fall back to defaults
+// See: https://aws.amazon.com/blogs/architecture/exponential-backoff-and-jitter/
+type BackoffPolicy struct {
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+	Multiplier float64
+	Jitter     Jitter
+	// MaxRetryAfter caps the delay a server can ask for with Retry-After
+	MaxRetryAfter time.Duration
+}
+
+func init() {
+	rand.Seed(time.Now().UTC().UnixNano())
+}
+
+func (p BackoffPolicy) withDefaults() BackoffPolicy {
+	if p.BaseDelay <= 0 {
+		p.BaseDelay = 100 * time.Millisecond
+	}
+	if p.MaxDelay <= 0 {
+		p.MaxDelay = 10 * time.Second
+	}
+	if p.Multiplier < 1 {
+		p.Multiplier = 2
+	}
+	if p.MaxRetryAfter <= 0 {
+		p.MaxRetryAfter = time.Minute
+	}
+	return p
+}
+
+// Delay returns how long to wait before the given retry, starting from 1
+func (p BackoffPolicy) Delay(retry int) time.Duration {
+	p = p.withDefaults()
+	if retry < 1 {
+		retry = 1
+	}
+
+	switch p.Jitter {
+	case NoJitter:
+		return p.exponential(retry)
+	case DecorrelatedJitter:
+		// the chain is replayed from the start so no state is kept between attempts
+		prev := p.BaseDelay
+		for i := 0; i < retry; i++ {
+			upper := math.Min(float64(p.MaxDelay), float64(prev)*p.Multiplier)
+			prev = time.Duration(float64(p.BaseDelay) + rand.Float64()*(upper-float64(p.BaseDelay)))
+		}
+		return prev
+	default:
+		return time.Duration(rand.Float64() * float64(p.exponential(retry)))
+	}
+}
+
+func (p BackoffPolicy) exponential(retry int) time.Duration {
+	delay := float64(p.BaseDelay) * math.Pow(p.Multiplier, float64(retry-1))
+	return time.Duration(math.Min(delay, float64(p.MaxDelay)))
+}
+
+// RetryAfter returns a resty.RetryAfterFunc honouring the Retry-After header on
+// 429 and 503 responses and falling back to Delay otherwise
+func (p BackoffPolicy) RetryAfter() resty.RetryAfterFunc {
+	p = p.withDefaults()
+	return func(c *resty.Client, r *resty.Response) (time.Duration, error) {
+		delay := p.Delay(r.Request.Attempt)
+
+		switch r.StatusCode() {
+		case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+			if after, ok := ParseRetryAfter(r.Header().Get("Retry-After"), time.Now()); ok {
+				delay = after
+				if delay > p.MaxRetryAfter {
+					delay = p.MaxRetryAfter
+				}
+			}
+		}
+
+		// resty reads 0 as "use the default algorithm"
+		if delay <= 0 {
+			delay = time.Nanosecond
+		}
+		return delay, nil
+	}
+}
+
+// apply configures the retry waits of client
+func (p BackoffPolicy) apply(client *resty.Client) {
+	p = p.withDefaults()
+	max := p.MaxDelay
+	if p.MaxRetryAfter > max {
+		max = p.MaxRetryAfter
+	}
+	client.
+		SetRetryWaitTime(time.Nanosecond).
+		SetRetryMaxWaitTime(max).
+		SetRetryAfter(p.RetryAfter())
+}
+
+// ParseRetryAfter parses a Retry-After header value, either delay-seconds or an HTTP-date
+func ParseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if d := date.Sub(now); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
@@ -0,0 +1,125 @@
+package httpclient_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/SpazioDati/go-utils/httpclient"
+	"github.com/go-resty/resty/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	assert := assert.New(t)
+
+	policy := BackoffPolicy{
+		BaseDelay:  10 * time.Millisecond,
+		MaxDelay:   50 * time.Millisecond,
+		Multiplier: 2,
+		Jitter:     NoJitter,
+	}
+	for _, test := range []struct {
+		retry int
+		out   time.Duration
+	}{
+		{0, 10 * time.Millisecond},
+		{1, 10 * time.Millisecond},
+		{2, 20 * time.Millisecond},
+		{3, 40 * time.Millisecond},
+		{4, 50 * time.Millisecond},
+		{10, 50 * time.Millisecond},
+	} {
+		assert.Equal(test.out, policy.Delay(test.retry))
+	}
+
+	for retry := 1; retry < 10; retry++ {
+		policy.Jitter = FullJitter
+		delay := policy.Delay(retry)
+		assert.True(delay >= 0 && delay <= 50*time.Millisecond)
+
+		policy.Jitter = DecorrelatedJitter
+		delay = policy.Delay(retry)
+		assert.True(delay >= 10*time.Millisecond && delay <= 50*time.Millisecond)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Date(2021, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	for _, test := range []struct {
+		in  string
+		out time.Duration
+		ok  bool
+	}{
+		{"", 0, false},
+		{"foobar", 0, false},
+		{"-1", 0, false},
+		{"120", 2 * time.Minute, true},
+		{"Sat, 01 May 2021 10:00:30 GMT", 30 * time.Second, true},
+		{"Sat, 01 May 2021 09:00:00 GMT", 0, true},
+	} {
+		out, ok := ParseRetryAfter(test.in, now)
+		assert.Equal(test.ok, ok, test.in)
+		assert.Equal(test.out, out, test.in)
+	}
+}
+
+func TestBackoffRetryAfter(t *testing.T) {
+	assert := assert.New(t)
+	fn := BackoffPolicy{
+		BaseDelay:     time.Millisecond,
+		MaxDelay:      time.Millisecond,
+		Jitter:        NoJitter,
+		MaxRetryAfter: 5 * time.Second,
+	}.RetryAfter()
+
+	response := func(code int, retryAfter string) *resty.Response {
+		return &resty.Response{
+			Request: &resty.Request{Attempt: 1},
+			RawResponse: &http.Response{
+				StatusCode: code,
+				Header:     http.Header{"Retry-After": []string{retryAfter}},
+			},
+		}
+	}
+
+	out, err := fn(nil, response(http.StatusTooManyRequests, "2"))
+	assert.Nil(err)
+	assert.Equal(2*time.Second, out)
+
+	out, _ = fn(nil, response(http.StatusServiceUnavailable, "3600"))
+	assert.Equal(5*time.Second, out, "capped by MaxRetryAfter")
+
+	out, _ = fn(nil, response(http.StatusInternalServerError, "2"))
+	assert.Equal(time.Millisecond, out, "ignored on other status codes")
+}
+
+func TestBackoffClient(t *testing.T) {
+	assert := assert.New(t)
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	client := New(Options{
+		HTTPClient: &http.Client{},
+		Logger:     &logMock{},
+		Retries:    2,
+		Backoff:    &BackoffPolicy{BaseDelay: time.Millisecond, MaxDelay: time.Millisecond},
+	})
+
+	resp, err := client.R().Get(ts.URL)
+	assert.Nil(err)
+	assert.Equal(http.StatusOK, resp.StatusCode())
+	assert.Equal(2, calls)
+}
@@ -0,0 +1,381 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Strategy selects the endpoint of a Balancer receiving a request
+type Strategy int
+
+const (
+	// RoundRobin cycles through the healthy endpoints
+	RoundRobin Strategy = iota
+	// LeastOutstanding picks the healthy endpoint with the fewest requests in flight
+	LeastOutstanding
+	// PriorityFailover sends every request to the first healthy endpoint
+	PriorityFailover
+)
+
+func (s Strategy) String() string {
+	switch s {
+	case RoundRobin:
+		return "round-robin"
+	case LeastOutstanding:
+		return "least-outstanding"
+	case PriorityFailover:
+		return "priority-failover"
+	}
+	return fmt.Sprintf("unknown(%d)", int(s))
+}
+
+// BalancerOptions describes the replicas of a single logical service
+type BalancerOptions struct {
+	// Endpoints are the base URLs of the replicas, in priority order
+	Endpoints []string
+	Strategy  Strategy
+	// EjectAfter is the number of consecutive failures ejecting an endpoint, defaults to 3
+	EjectAfter int
+	// EjectFor is how long an ejected endpoint receives no requests, defaults to 30s
+	EjectFor time.Duration
+	// IsFailure classifies an attempt, defaults to transport errors and 5xx responses
+	IsFailure func(*http.Response, error) bool
+	// HealthPath, when set, is requested on every endpoint each HealthInterval (10s
+	// by default); an endpoint failing the probe receives no requests until it
+	// passes again
+	HealthPath     string
+	HealthInterval time.Duration
+	// HealthClient sends the probes, defaults to a client with a 5s timeout
+	HealthClient *http.Client
+}
+
+// EndpointStats is a snapshot of an endpoint of a Balancer
+type EndpointStats struct {
+	URL          string    `json:"url"`
+	Healthy      bool      `json:"healthy"`
+	Outstanding  int       `json:"outstanding"`
+	Failures     int       `json:"failures"`
+	EjectedUntil time.Time `json:"ejected_until,omitempty"`
+}
+
+// endpoint holds the state of a single replica
+type endpoint struct {
+	base         *url.URL
+	outstanding  int
+	failures     int
+	ejectedUntil time.Time
+	// down is set by the health probes
+	down bool
+}
+
+func (e *endpoint) healthy(now time.Time) bool {
+	return !e.down && !now.Before(e.ejectedUntil)
+}
+
+// Balancer spreads the requests to a logical service over its endpoints, skipping
+// those failing repeatedly
+type Balancer struct {
+	opts      BalancerOptions
+	logger    resty.Logger
+	mu        sync.Mutex
+	endpoints []*endpoint
+	next      int
+	stop      chan struct{}
+	once      sync.Once
+}
+
+// NewBalancer returns a Balancer, zero values in opts are replaced by sensible
+// defaults. With a HealthPath the endpoints are probed until Close.
+func NewBalancer(logger resty.Logger, opts BalancerOptions) (*Balancer, error) {
+	if len(opts.Endpoints) == 0 {
+		return nil, errors.New("httpclient: balancer needs at least one endpoint")
+	}
+	if opts.EjectAfter <= 0 {
+		opts.EjectAfter = 3
+	}
+	if opts.EjectFor <= 0 {
+		opts.EjectFor = 30 * time.Second
+	}
+	if opts.IsFailure == nil {
+		opts.IsFailure = isFailure
+	}
+	if opts.HealthInterval <= 0 {
+		opts.HealthInterval = 10 * time.Second
+	}
+	if opts.HealthClient == nil {
+		opts.HealthClient = &http.Client{Timeout: 5 * time.Second}
+	}
+
+	b := &Balancer{
+		opts:   opts,
+		logger: logger,
+		stop:   make(chan struct{}),
+	}
+	for _, raw := range opts.Endpoints {
+		base, err := url.Parse(raw)
+		if err != nil || base.Scheme == "" || base.Host == "" {
+			return nil, fmt.Errorf("httpclient: invalid balancer endpoint %q", raw)
+		}
+		base.Path = strings.TrimSuffix(base.Path, "/")
+		b.endpoints = append(b.endpoints, &endpoint{base: base})
+	}
+
+	if opts.HealthPath != "" {
+		go b.probeLoop()
+	}
+	return b, nil
+}
+
+// BaseURL returns the first endpoint, requests sent to any endpoint are balanced
+func (b *Balancer) BaseURL() string {
+	return b.endpoints[0].base.String()
+}
+
+// Endpoints returns a snapshot of every endpoint
+func (b *Balancer) Endpoints() []EndpointStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	ret := make([]EndpointStats, len(b.endpoints))
+	for i, e := range b.endpoints {
+		ret[i] = EndpointStats{
+			URL:         e.base.String(),
+			Healthy:     e.healthy(now),
+			Outstanding: e.outstanding,
+			Failures:    e.failures,
+		}
+		if now.Before(e.ejectedUntil) {
+			ret[i].EjectedUntil = e.ejectedUntil
+		}
+	}
+	return ret
+}
+
+// Close stops the health probes
+func (b *Balancer) Close() {
+	b.once.Do(func() { close(b.stop) })
+}
+
+// match returns the path of u relative to the endpoint it targets
+func (b *Balancer) match(u *url.URL) (string, bool) {
+	for _, e := range b.endpoints {
+		if u.Scheme != e.base.Scheme || u.Host != e.base.Host {
+			continue
+		}
+		if u.Path == e.base.Path || strings.HasPrefix(u.Path, e.base.Path+"/") {
+			return strings.TrimPrefix(u.Path, e.base.Path), true
+		}
+	}
+	return "", false
+}
+
+// pick selects an endpoint for the next attempt and counts it as outstanding.
+// Healthy endpoints not tried yet by the request come first; when every endpoint
+// is ejected the request is sent anyway.
+func (b *Balancer) pick(tried map[*endpoint]bool) *endpoint {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	filters := []func(*endpoint) bool{
+		func(e *endpoint) bool { return e.healthy(now) && !tried[e] },
+		func(e *endpoint) bool { return e.healthy(now) },
+		func(e *endpoint) bool { return !tried[e] },
+		func(e *endpoint) bool { return true },
+	}
+	var candidates []int
+	for _, keep := range filters {
+		candidates = candidates[:0]
+		for i, e := range b.endpoints {
+			if keep(e) {
+				candidates = append(candidates, i)
+			}
+		}
+		if len(candidates) > 0 {
+			break
+		}
+	}
+
+	chosen := candidates[0]
+	switch b.opts.Strategy {
+	case RoundRobin, LeastOutstanding:
+		// the first candidate at or after next, ties included, so that the
+		// requests rotate
+		n := len(b.endpoints)
+		best := -1
+		for offset := 0; offset < n; offset++ {
+			i := (b.next + offset) % n
+			if !contains(candidates, i) {
+				continue
+			}
+			if best < 0 || (b.opts.Strategy == LeastOutstanding && b.endpoints[i].outstanding < b.endpoints[best].outstanding) {
+				best = i
+			}
+			if b.opts.Strategy == RoundRobin {
+				break
+			}
+		}
+		chosen = best
+		b.next = chosen + 1
+	}
+
+	e := b.endpoints[chosen]
+	e.outstanding++
+	if tried != nil {
+		tried[e] = true
+	}
+	return e
+}
+
+func contains(values []int, v int) bool {
+	for _, x := range values {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+// record counts the outcome of an attempt to e, ejecting it after too many
+// consecutive failures
+func (b *Balancer) record(e *endpoint, resp *http.Response, err error) {
+	if errors.Is(err, context.Canceled) {
+		// the caller gave up, not a failure of the endpoint
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.opts.IsFailure(resp, err) {
+		e.failures = 0
+		return
+	}
+	e.failures++
+	if e.failures < b.opts.EjectAfter {
+		return
+	}
+	e.failures = 0
+	e.ejectedUntil = time.Now().Add(b.opts.EjectFor)
+	if b.logger != nil {
+		b.logger.Warnf("Endpoint %s ejected for %s after %d consecutive failures", e.base, b.opts.EjectFor, b.opts.EjectAfter)
+	}
+}
+
+// release ends an attempt to e
+func (b *Balancer) release(e *endpoint) func() {
+	once := sync.Once{}
+	return func() {
+		once.Do(func() {
+			b.mu.Lock()
+			e.outstanding--
+			b.mu.Unlock()
+		})
+	}
+}
+
+func (b *Balancer) probeLoop() {
+	ticker := time.NewTicker(b.opts.HealthInterval)
+	defer ticker.Stop()
+	for {
+		for _, e := range b.endpoints {
+			b.probe(e)
+		}
+		select {
+		case <-b.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (b *Balancer) probe(e *endpoint) {
+	resp, err := b.opts.HealthClient.Get(e.base.String() + b.opts.HealthPath)
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			err = fmt.Errorf("status %d", resp.StatusCode)
+		}
+	}
+
+	b.mu.Lock()
+	changed := e.down != (err != nil)
+	e.down = err != nil
+	b.mu.Unlock()
+
+	if !changed || b.logger == nil {
+		return
+	}
+	if err != nil {
+		b.logger.Warnf("Endpoint %s failed its health check: %v", e.base, err)
+	} else {
+		b.logger.Warnf("Endpoint %s passed its health check", e.base)
+	}
+}
+
+type balanceInfoKey struct{}
+
+// balanceInfo holds the endpoints tried by the attempts of a request
+type balanceInfo struct {
+	tried map[*endpoint]bool
+}
+
+// OnBeforeRequest returns a middleware letting BalancerTransport send the retries
+// of a request to endpoints it did not try yet. It must run before PropagateTracing
+// so that every attempt shares the same record.
+func (b *Balancer) OnBeforeRequest() resty.RequestMiddleware {
+	return func(c *resty.Client, r *resty.Request) error {
+		if _, ok := r.Context().Value(balanceInfoKey{}).(*balanceInfo); !ok {
+			r.SetContext(context.WithValue(r.Context(), balanceInfoKey{}, &balanceInfo{tried: map[*endpoint]bool{}}))
+		}
+		return nil
+	}
+}
+
+// BalancerTransport defines a http.RoundTripper sending the requests to any endpoint
+// of a Balancer to the endpoint it picks. Other requests are forwarded unchanged.
+type BalancerTransport struct {
+	T        http.RoundTripper
+	Balancer *Balancer
+}
+
+func (bt *BalancerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	path, ok := bt.Balancer.match(req.URL)
+	if !ok {
+		return bt.T.RoundTrip(req)
+	}
+
+	var tried map[*endpoint]bool
+	if info, ok := req.Context().Value(balanceInfoKey{}).(*balanceInfo); ok {
+		tried = info.tried
+	}
+	e := bt.Balancer.pick(tried)
+	release := bt.Balancer.release(e)
+	attemptSpan(req.Context()).SetAttributes(attribute.String("http.endpoint", e.base.String()))
+
+	out := req.Clone(req.Context())
+	out.URL.Scheme = e.base.Scheme
+	out.URL.Host = e.base.Host
+	out.URL.Path = e.base.Path + path
+	out.URL.RawPath = ""
+	out.Host = ""
+
+	resp, err := bt.T.RoundTrip(out)
+	bt.Balancer.record(e, resp, err)
+	if err != nil || resp.Body == nil {
+		release()
+		return resp, err
+	}
+	resp.Body = &releaseOnClose{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
@@ -0,0 +1,224 @@
+package httpclient_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/SpazioDati/go-utils/httpclient"
+	"github.com/stretchr/testify/assert"
+)
+
+// replica counts its requests under /api and answers with status
+type replica struct {
+	*httptest.Server
+	hits   int32
+	status int32
+	health int32
+}
+
+func newReplica(name string) *replica {
+	r := &replica{status: http.StatusOK, health: http.StatusOK}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/api/health" {
+			w.WriteHeader(int(atomic.LoadInt32(&r.health)))
+			return
+		}
+		if req.URL.Path != "/api/users" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		atomic.AddInt32(&r.hits, 1)
+		w.WriteHeader(int(atomic.LoadInt32(&r.status)))
+		w.Write([]byte(name))
+	}))
+	return r
+}
+
+func (r *replica) Hits() int {
+	return int(atomic.LoadInt32(&r.hits))
+}
+
+func TestBalancerRoundRobin(t *testing.T) {
+	assert := assert.New(t)
+	replicas := []*replica{newReplica("a"), newReplica("b"), newReplica("c")}
+	endpoints := []string{}
+	for _, r := range replicas {
+		defer r.Close()
+		endpoints = append(endpoints, r.URL+"/api/")
+	}
+
+	balancer, err := NewBalancer(nil, BalancerOptions{Endpoints: endpoints})
+	assert.Nil(err)
+	client := New(Options{
+		HTTPClient:     &http.Client{},
+		Logger:         &logMock{},
+		DisableTracing: true,
+		Balancer:       balancer,
+	})
+
+	names := ""
+	for i := 0; i < 6; i++ {
+		resp, err := client.R().Get("/users")
+		assert.Nil(err)
+		names += resp.String()
+	}
+	assert.Equal("abcabc", names)
+
+	resp, err := client.R().Get(replicas[2].URL + "/api/users")
+	assert.Nil(err)
+	assert.Equal("a", resp.String(), "any endpoint URL is balanced")
+
+	other := newReplica("other")
+	defer other.Close()
+	resp, err = client.R().Get(other.URL + "/api/users")
+	assert.Nil(err)
+	assert.Equal("other", resp.String(), "other hosts are left untouched")
+}
+
+func TestBalancerFailover(t *testing.T) {
+	assert := assert.New(t)
+	primary, secondary := newReplica("primary"), newReplica("secondary")
+	defer primary.Close()
+	defer secondary.Close()
+	atomic.StoreInt32(&primary.status, http.StatusServiceUnavailable)
+
+	logger := &logMock{}
+	balancer, err := NewBalancer(logger, BalancerOptions{
+		Endpoints:  []string{primary.URL + "/api", secondary.URL + "/api"},
+		Strategy:   PriorityFailover,
+		EjectAfter: 2,
+		EjectFor:   time.Minute,
+	})
+	assert.Nil(err)
+	client := New(Options{
+		HTTPClient:     &http.Client{},
+		Logger:         logger,
+		Retries:        1,
+		DisableTracing: true,
+		Backoff:        &BackoffPolicy{BaseDelay: time.Millisecond},
+		Balancer:       balancer,
+	})
+
+	for i := 0; i < 2; i++ {
+		resp, err := client.R().Get("/users")
+		assert.Nil(err)
+		assert.Equal("secondary", resp.String(), "the retry goes to another endpoint")
+	}
+	assert.Equal(2, primary.Hits())
+	assert.Equal("Endpoint %s ejected for %s after %d consecutive failures", logger.Format)
+
+	stats := balancer.Endpoints()
+	assert.False(stats[0].Healthy)
+	assert.False(stats[0].EjectedUntil.IsZero())
+	assert.True(stats[1].Healthy)
+
+	resp, err := client.R().Get("/users")
+	assert.Nil(err)
+	assert.Equal("secondary", resp.String())
+	assert.Equal(2, primary.Hits(), "an ejected endpoint gets no requests")
+
+	atomic.StoreInt32(&secondary.status, http.StatusServiceUnavailable)
+	client.R().Get("/users")
+	assert.Equal(2, primary.Hits(), "a healthy endpoint is retried before an ejected one")
+	assert.False(balancer.Endpoints()[1].Healthy)
+
+	resp, err = client.R().Get("/users")
+	assert.Nil(err)
+	assert.Equal(http.StatusServiceUnavailable, resp.StatusCode())
+	assert.Equal(3, primary.Hits(), "ejected endpoints are still tried when nothing else is left")
+}
+
+func TestBalancerLeastOutstanding(t *testing.T) {
+	assert := assert.New(t)
+	release := make(chan struct{})
+	started := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.Write([]byte("slow"))
+	}))
+	defer slow.Close()
+	fast := newReplica("fast")
+	defer fast.Close()
+
+	balancer, err := NewBalancer(nil, BalancerOptions{
+		Endpoints: []string{slow.URL + "/api", fast.URL + "/api"},
+		Strategy:  LeastOutstanding,
+	})
+	assert.Nil(err)
+	client := New(Options{
+		HTTPClient:     &http.Client{},
+		Logger:         &logMock{},
+		DisableTracing: true,
+		Balancer:       balancer,
+	})
+
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		resp, err := client.R().Get("/users")
+		assert.Nil(err)
+		assert.Equal("slow", resp.String())
+	}()
+	<-started
+	assert.Equal(1, balancer.Endpoints()[0].Outstanding)
+
+	for i := 0; i < 3; i++ {
+		resp, err := client.R().Get("/users")
+		assert.Nil(err)
+		assert.Equal("fast", resp.String())
+	}
+	close(release)
+	wg.Wait()
+	assert.Equal(0, balancer.Endpoints()[0].Outstanding)
+}
+
+func TestBalancerHealthProbes(t *testing.T) {
+	assert := assert.New(t)
+	primary, secondary := newReplica("primary"), newReplica("secondary")
+	defer primary.Close()
+	defer secondary.Close()
+	atomic.StoreInt32(&primary.health, http.StatusServiceUnavailable)
+
+	logger := &logMock{}
+	balancer, err := NewBalancer(logger, BalancerOptions{
+		Endpoints:      []string{primary.URL + "/api", secondary.URL + "/api"},
+		Strategy:       PriorityFailover,
+		HealthPath:     "/health",
+		HealthInterval: 10 * time.Millisecond,
+	})
+	assert.Nil(err)
+	defer balancer.Close()
+
+	assert.Eventually(func() bool { return !balancer.Endpoints()[0].Healthy }, time.Second, 5*time.Millisecond)
+	client := New(Options{
+		HTTPClient:     &http.Client{},
+		Logger:         &logMock{},
+		DisableTracing: true,
+		Balancer:       balancer,
+	})
+	resp, err := client.R().Get("/users")
+	assert.Nil(err)
+	assert.Equal("secondary", resp.String())
+
+	atomic.StoreInt32(&primary.health, http.StatusOK)
+	assert.Eventually(func() bool { return balancer.Endpoints()[0].Healthy }, time.Second, 5*time.Millisecond)
+	resp, err = client.R().Get("/users")
+	assert.Nil(err)
+	assert.Equal("primary", resp.String())
+}
+
+func TestBalancerErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := NewBalancer(nil, BalancerOptions{})
+	assert.EqualError(err, "httpclient: balancer needs at least one endpoint")
+
+	_, err = NewBalancer(nil, BalancerOptions{Endpoints: []string{"localhost:8080"}})
+	assert.EqualError(err, `httpclient: invalid balancer endpoint "localhost:8080"`)
+}
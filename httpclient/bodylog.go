@@ -0,0 +1,304 @@
+package httpclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-resty/resty/v2"
+)
+
+const redacted = "[REDACTED]"
+
+// DefaultRedactedHeaders are always redacted by a BodyLogger, along with the query
+// parameters of the same name, e.g. api_key or apikey for X-Api-Key
+var DefaultRedactedHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"Set-Cookie",
+	"X-Api-Key",
+	"Api-Key",
+	"X-Access-Token",
+	"X-Auth-Token",
+}
+
+// BodyLogOptions configures a BodyLogger
+type BodyLogOptions struct {
+	// RedactHeaders are redacted along with DefaultRedactedHeaders, as headers and
+	// query parameters
+	RedactHeaders []string
+	// RedactFields are dot separated paths of JSON fields to redact, "*" matches any
+	// key or index, e.g. "user.password" or "items.*.token". Top level paths apply to
+	// form fields too.
+	RedactFields []string
+	// MaxBodySize truncates the logged bodies, defaults to 2048 bytes
+	MaxBodySize int
+	// SampleRate is the fraction of requests logged, defaults to 1
+	SampleRate float64
+}
+
+// BodyLogger logs the headers and bodies of requests and responses while enabled
+type BodyLogger struct {
+	opts    BodyLogOptions
+	headers map[string]bool
+	params  map[string]bool
+	fields  [][]string
+	// until is the UnixNano time the logging stops at
+	until int64
+}
+
+// DefaultBodyLogger is the logger used by EnableBodyLog, BodyLogEnablerMW and the
+// clients built by New without Options.BodyLogger
+var DefaultBodyLogger = NewBodyLogger(BodyLogOptions{})
+
+func NewBodyLogger(opts BodyLogOptions) *BodyLogger {
+	if opts.MaxBodySize <= 0 {
+		opts.MaxBodySize = 2048
+	}
+	if opts.SampleRate <= 0 || opts.SampleRate > 1 {
+		opts.SampleRate = 1
+	}
+
+	b := &BodyLogger{
+		opts:    opts,
+		headers: map[string]bool{},
+		params:  map[string]bool{},
+	}
+	for _, h := range append(append([]string{}, DefaultRedactedHeaders...), opts.RedactHeaders...) {
+		b.headers[http.CanonicalHeaderKey(h)] = true
+		b.params[paramKey(h)] = true
+	}
+	for _, f := range opts.RedactFields {
+		b.fields = append(b.fields, strings.Split(f, "."))
+	}
+	return b
+}
+
+// Enable logs the bodies for timeout, a longer enable still running is kept
+func (b *BodyLogger) Enable(timeout time.Duration) {
+	until := time.Now().Add(timeout).UnixNano()
+	for {
+		current := atomic.LoadInt64(&b.until)
+		if current >= until || atomic.CompareAndSwapInt64(&b.until, current, until) {
+			return
+		}
+	}
+}
+
+func (b *BodyLogger) Disable() {
+	atomic.StoreInt64(&b.until, 0)
+}
+
+func (b *BodyLogger) Enabled() bool {
+	return time.Now().UnixNano() < atomic.LoadInt64(&b.until)
+}
+
+// sampled tells if the current request is logged
+func (b *BodyLogger) sampled() bool {
+	return b.Enabled() && (b.opts.SampleRate >= 1 || rand.Float64() < b.opts.SampleRate)
+}
+
+func (b *BodyLogger) redactHeaders(h http.Header) http.Header {
+	ret := make(http.Header, len(h))
+	for name, values := range h {
+		if b.headers[http.CanonicalHeaderKey(name)] {
+			ret[name] = []string{redacted}
+			continue
+		}
+		ret[name] = values
+	}
+	return ret
+}
+
+// paramKey normalizes a header or query parameter name, so that X-Api-Key, api_key
+// and apiKey match
+func paramKey(name string) string {
+	name = strings.ToLower(name)
+	name = strings.TrimPrefix(name, "x-")
+	return strings.NewReplacer("-", "", "_", "").Replace(name)
+}
+
+// redactURL redacts the values of the query parameters named as a redacted header
+func (b *BodyLogger) redactURL(raw string) string {
+	i := strings.IndexByte(raw, '?')
+	if i < 0 {
+		return raw
+	}
+	pairs := strings.Split(raw[i+1:], "&")
+	for j, pair := range pairs {
+		name := strings.SplitN(pair, "=", 2)[0]
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+		if b.params[paramKey(name)] {
+			pairs[j] = strings.SplitN(pair, "=", 2)[0] + "=" + redacted
+		}
+	}
+	return raw[:i+1] + strings.Join(pairs, "&")
+}
+
+// redactError redacts the URL of the *url.Error in err, as redactURL does
+func (b *BodyLogger) redactError(err error) string {
+	msg := err.Error()
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) && urlErr.URL != "" {
+		msg = strings.Replace(msg, urlErr.URL, b.redactURL(urlErr.URL), -1)
+	}
+	return msg
+}
+
+func redactJSON(node interface{}, path []string) {
+	switch n := node.(type) {
+	case map[string]interface{}:
+		for key, child := range n {
+			if path[0] != "*" && path[0] != key {
+				continue
+			}
+			if len(path) == 1 {
+				n[key] = redacted
+				continue
+			}
+			redactJSON(child, path[1:])
+		}
+	case []interface{}:
+		for i, child := range n {
+			if path[0] != "*" && path[0] != strconv.Itoa(i) {
+				continue
+			}
+			if len(path) == 1 {
+				n[i] = redacted
+				continue
+			}
+			redactJSON(child, path[1:])
+		}
+	}
+}
+
+// redactBody redacts the configured fields of a JSON or form body and truncates it
+func (b *BodyLogger) redactBody(body []byte, contentType string) string {
+	if len(body) > 0 && len(b.fields) > 0 {
+		if strings.Contains(contentType, "application/x-www-form-urlencoded") {
+			if form, err := url.ParseQuery(string(body)); err == nil {
+				for _, path := range b.fields {
+					if _, ok := form[path[0]]; ok && len(path) == 1 {
+						form.Set(path[0], redacted)
+					}
+				}
+				body = []byte(form.Encode())
+			}
+		} else {
+			var node interface{}
+			decoder := json.NewDecoder(bytes.NewReader(body))
+			decoder.UseNumber()
+			if err := decoder.Decode(&node); err == nil {
+				for _, path := range b.fields {
+					redactJSON(node, path)
+				}
+				if encoded, err := json.Marshal(node); err == nil {
+					body = encoded
+				}
+			}
+		}
+	}
+
+	if len(body) > b.opts.MaxBodySize {
+		return fmt.Sprintf("%s... (%d bytes truncated)", body[:b.opts.MaxBodySize], len(body)-b.opts.MaxBodySize)
+	}
+	return string(body)
+}
+
+// requestBody returns the body resty sent for r, readers can not be read twice
+func requestBody(r *resty.Request) []byte {
+	switch body := r.Body.(type) {
+	case nil:
+		if len(r.FormData) > 0 {
+			return []byte(r.FormData.Encode())
+		}
+		return nil
+	case []byte:
+		return body
+	case string:
+		return []byte(body)
+	case io.Reader:
+		return []byte("[stream]")
+	default:
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return []byte(fmt.Sprintf("[%T]", body))
+		}
+		return encoded
+	}
+}
+
+func (b *BodyLogger) logRequest(logger resty.Logger, r *resty.Request) {
+	header := r.Header
+	if r.RawRequest != nil {
+		header = r.RawRequest.Header
+	}
+	logger.Warnf("Resty request: %s %s headers=%v body=%s",
+		r.Method, b.redactURL(r.URL), b.redactHeaders(header), b.redactBody(requestBody(r), header.Get("Content-Type")))
+}
+
+// OnAfterResponse returns a middleware logging every attempt with a response
+func (b *BodyLogger) OnAfterResponse(logger resty.Logger) resty.ResponseMiddleware {
+	return func(c *resty.Client, r *resty.Response) error {
+		if !b.sampled() {
+			return nil
+		}
+		b.logRequest(logger, r.Request)
+		logger.Warnf("Resty response: %d %s %s headers=%v body=%s",
+			r.StatusCode(), r.Request.Method, b.redactURL(r.Request.URL), b.redactHeaders(r.Header()),
+			b.redactBody(r.Body(), r.Header().Get("Content-Type")))
+		return nil
+	}
+}
+
+// OnError returns a hook logging the requests failed without a response
+func (b *BodyLogger) OnError(logger resty.Logger) resty.ErrorHook {
+	return func(r *resty.Request, err error) {
+		var respErr *resty.ResponseError
+		if errors.As(err, &respErr) && respErr.Response != nil && respErr.Response.RawResponse != nil {
+			// already logged by OnAfterResponse
+			return
+		}
+		if !b.sampled() {
+			return
+		}
+		b.logRequest(logger, r)
+		logger.Warnf("Resty response: %s %s error=%s", r.Method, b.redactURL(r.URL), b.redactError(err))
+	}
+}
+
+// EnableBodyLog logs the bodies of the requests sent by DefaultBodyLogger for timeout
+func EnableBodyLog(timeout time.Duration) {
+	DefaultBodyLogger.Enable(timeout)
+}
+
+func DisableBodyLog() {
+	DefaultBodyLogger.Disable()
+}
+
+func IsBodyLogEnabled() bool {
+	return DefaultBodyLogger.Enabled()
+}
+
+// BodyLogEnablerMW enables DefaultBodyLogger for "to" minutes (default 15)
+func BodyLogEnablerMW(logger resty.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		to := sessionTimeout(c)
+		logger.Warnf("Enabling resty body log for %d minutes", int(to.Minutes()))
+		EnableBodyLog(to)
+		c.String(http.StatusOK, "OK")
+	}
+}
@@ -0,0 +1,119 @@
+package httpclient_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/SpazioDati/go-utils/httpclient"
+	"github.com/stretchr/testify/assert"
+)
+
+// linesLogger keeps every formatted line
+type linesLogger struct {
+	lines []string
+}
+
+func (l *linesLogger) Errorf(format string, values ...interface{}) {
+	l.lines = append(l.lines, fmt.Sprintf(format, values...))
+}
+
+func (l *linesLogger) Warnf(format string, values ...interface{}) {
+	l.lines = append(l.lines, fmt.Sprintf(format, values...))
+}
+
+func (l *linesLogger) Debugf(format string, values ...interface{}) {
+	l.lines = append(l.lines, fmt.Sprintf(format, values...))
+}
+
+func TestBodyLogger(t *testing.T) {
+	assert := assert.New(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Set-Cookie", "session=secret")
+		w.Write([]byte(`{"token":"secret","items":[{"id":1,"key":"secret"}],"padding":"` + strings.Repeat("x", 100) + `"}`))
+	}))
+	defer ts.Close()
+
+	logger := &linesLogger{}
+	bodyLogger := NewBodyLogger(BodyLogOptions{
+		RedactHeaders: []string{"X-Partner-Secret"},
+		RedactFields:  []string{"password", "token", "items.*.key"},
+		MaxBodySize:   80,
+	})
+	client := New(Options{
+		HTTPClient:     &http.Client{},
+		Logger:         logger,
+		DisableTracing: true,
+		BodyLogger:     bodyLogger,
+	})
+
+	_, err := client.R().SetBody(map[string]string{"user": "me", "password": "secret"}).Post(ts.URL)
+	assert.Nil(err)
+	assert.Empty(logger.lines, "disabled by default")
+
+	bodyLogger.Enable(time.Minute)
+	_, err = client.R().
+		SetHeader("Authorization", "Bearer secret").
+		SetHeader("X-Partner-Secret", "secret").
+		SetQueryParam("api_key", "secret").
+		SetQueryParam("apiKey", "secret").
+		SetQueryParam("page", "2").
+		SetBody(map[string]string{"user": "me", "password": "secret"}).
+		Post(ts.URL)
+	assert.Nil(err)
+
+	assert.Len(logger.lines, 2)
+	for _, line := range logger.lines {
+		assert.NotContains(line, "secret")
+	}
+	assert.Contains(logger.lines[0], `"user":"me"`)
+	assert.Contains(logger.lines[0], "?apiKey=[REDACTED]&api_key=[REDACTED]&page=2")
+	assert.Contains(logger.lines[1], `"items":[{"id":1,"key":"[REDACTED]"}]`)
+	assert.Contains(logger.lines[1], "bytes truncated")
+
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	resp, err := client.R().SetQueryParam("api_key", "secret").Get(closed.URL)
+	assert.NotNil(err)
+	assert.Len(logger.lines, 4)
+	for _, line := range logger.lines[2:] {
+		assert.NotContains(line, "secret", "the URL in the error is redacted")
+	}
+	assert.NotContains(NewTraceRecord(resp.Request, 0, err).Error, "secret")
+
+	bodyLogger.Enable(time.Nanosecond)
+	assert.True(bodyLogger.Enabled(), "a shorter enable keeps the longer one")
+
+	bodyLogger.Disable()
+	_, err = client.R().Get(ts.URL)
+	assert.Nil(err)
+	assert.Len(logger.lines, 4)
+}
+
+func TestBodyLoggerSampling(t *testing.T) {
+	assert := assert.New(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	logger := &linesLogger{}
+	bodyLogger := NewBodyLogger(BodyLogOptions{SampleRate: 0.1})
+	bodyLogger.Enable(time.Minute)
+	client := New(Options{
+		HTTPClient:     &http.Client{},
+		Logger:         logger,
+		DisableTracing: true,
+		BodyLogger:     bodyLogger,
+	})
+
+	for i := 0; i < 200; i++ {
+		_, err := client.R().Get(ts.URL)
+		assert.Nil(err)
+	}
+	assert.True(len(logger.lines) > 0 && len(logger.lines) < 200, "about one request in ten is logged")
+}
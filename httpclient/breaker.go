@@ -0,0 +1,232 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// BreakerState is the state of the circuit guarding a single host
+type BreakerState int
+
+const (
+	StateClosed BreakerState = iota
+	StateOpen
+	StateHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("unknown(%d)", int(s))
+}
+
+// BreakerOptions configures when a circuit trips and how it recovers
+type BreakerOptions struct {
+	// FailureThreshold is the number of consecutive failures that opens the circuit
+	FailureThreshold int
+	// OpenTimeout is how long the circuit stays open before letting probes through
+	OpenTimeout time.Duration
+	// HalfOpenRequests is the number of probes allowed while half-open; the same
+	// number of consecutive successes closes the circuit again
+	HalfOpenRequests int
+	// IsFailure classifies an attempt, defaults to transport errors and 5xx responses
+	IsFailure func(*http.Response, error) bool
+}
+
+// CircuitOpenError is returned, without hitting the network, for requests to a host
+// whose circuit is open
+type CircuitOpenError struct {
+	Host  string
+	Until time.Time
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("httpclient: circuit open for host %s until %s", e.Host, e.Until.Format(time.RFC3339))
+}
+
+// circuit holds the state for a single host
+type circuit struct {
+	state     BreakerState
+	failures  int
+	successes int
+	probes    int
+	openedAt  time.Time
+}
+
+// Breaker tracks failures per target host and fails fast once a host is considered down
+type Breaker struct {
+	opts     BreakerOptions
+	logger   resty.Logger
+	mu       sync.Mutex
+	circuits map[string]*circuit
+}
+
+// NewBreaker returns a Breaker, zero values in opts are replaced by sensible defaults
+func NewBreaker(logger resty.Logger, opts BreakerOptions) *Breaker {
+	if opts.FailureThreshold <= 0 {
+		opts.FailureThreshold = 5
+	}
+	if opts.OpenTimeout <= 0 {
+		opts.OpenTimeout = 30 * time.Second
+	}
+	if opts.HalfOpenRequests <= 0 {
+		opts.HalfOpenRequests = 1
+	}
+	if opts.IsFailure == nil {
+		opts.IsFailure = isFailure
+	}
+	return &Breaker{
+		opts:     opts,
+		logger:   logger,
+		circuits: map[string]*circuit{},
+	}
+}
+
+// isFailure tells if an attempt failed because of the host, a canceled attempt, as
+// the loser of a hedge, is not
+func isFailure(resp *http.Response, err error) bool {
+	if err != nil {
+		return !errors.Is(err, context.Canceled)
+	}
+	return resp.StatusCode >= http.StatusInternalServerError
+}
+
+// Allow returns a *CircuitOpenError if a request to host must not be sent.
+// Every allowed request must be followed by a call to Record.
+func (b *Breaker) Allow(host string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c := b.circuit(host)
+	if c.state == StateOpen {
+		if time.Since(c.openedAt) < b.opts.OpenTimeout {
+			return &CircuitOpenError{Host: host, Until: c.openedAt.Add(b.opts.OpenTimeout)}
+		}
+		b.transition(host, c, StateHalfOpen)
+	}
+
+	if c.state == StateHalfOpen {
+		if c.probes >= b.opts.HalfOpenRequests {
+			return &CircuitOpenError{Host: host, Until: time.Now()}
+		}
+		c.probes++
+	}
+
+	return nil
+}
+
+// Record stores the outcome of a request previously allowed by Allow
+func (b *Breaker) Record(host string, resp *http.Response, err error) {
+	failed := b.opts.IsFailure(resp, err)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c := b.circuit(host)
+	if errors.Is(err, context.Canceled) {
+		// neither a failure nor a success of the host, the probe is given back
+		if c.state == StateHalfOpen && c.probes > 0 {
+			c.probes--
+		}
+		return
+	}
+	switch c.state {
+	case StateClosed:
+		if !failed {
+			c.failures = 0
+			return
+		}
+		c.failures++
+		if c.failures >= b.opts.FailureThreshold {
+			b.transition(host, c, StateOpen)
+		}
+	case StateHalfOpen:
+		if c.probes > 0 {
+			c.probes--
+		}
+		if failed {
+			b.transition(host, c, StateOpen)
+			return
+		}
+		c.successes++
+		if c.successes >= b.opts.HalfOpenRequests {
+			b.transition(host, c, StateClosed)
+		}
+	}
+}
+
+// State returns the current state of the circuit for host
+func (b *Breaker) State(host string) BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if c, ok := b.circuits[host]; ok {
+		return c.state
+	}
+	return StateClosed
+}
+
+// States returns a snapshot of the state of every known host
+func (b *Breaker) States() map[string]BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ret := make(map[string]BreakerState, len(b.circuits))
+	for host, c := range b.circuits {
+		ret[host] = c.state
+	}
+	return ret
+}
+
+func (b *Breaker) circuit(host string) *circuit {
+	c, ok := b.circuits[host]
+	if !ok {
+		c = &circuit{}
+		b.circuits[host] = c
+	}
+	return c
+}
+
+// transition must be called with b.mu held
+func (b *Breaker) transition(host string, c *circuit, to BreakerState) {
+	if b.logger != nil {
+		b.logger.Warnf("Circuit breaker for %s: %s -> %s", host, c.state, to)
+	}
+	c.state = to
+	c.failures = 0
+	c.successes = 0
+	c.probes = 0
+	if to == StateOpen {
+		c.openedAt = time.Now()
+	}
+}
+
+// BreakerTransport defines a http.RoundTripper guarded by a Breaker
+type BreakerTransport struct {
+	T       http.RoundTripper
+	Breaker *Breaker
+}
+
+// RoundTrip fails fast when the circuit for the target host is open, otherwise it
+// forwards the request and records the outcome
+func (bt *BreakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+	if err := bt.Breaker.Allow(host); err != nil {
+		return nil, err
+	}
+	resp, err := bt.T.RoundTrip(req)
+	bt.Breaker.Record(host, resp, err)
+	return resp, err
+}
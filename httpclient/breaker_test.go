@@ -0,0 +1,88 @@
+package httpclient_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/SpazioDati/go-utils/httpclient"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBreakerStates(t *testing.T) {
+	assert := assert.New(t)
+	logger := &logMock{}
+	b := NewBreaker(logger, BreakerOptions{
+		FailureThreshold: 2,
+		OpenTimeout:      10 * time.Millisecond,
+		HalfOpenRequests: 1,
+	})
+
+	assert.Equal(StateClosed, b.State("foo"))
+	assert.Nil(b.Allow("foo"))
+	b.Record("foo", nil, errors.New("foobar"))
+	assert.Equal(StateClosed, b.State("foo"))
+	assert.Nil(b.Allow("foo"))
+	b.Record("foo", &http.Response{StatusCode: http.StatusBadGateway}, nil)
+	assert.Equal(StateOpen, b.State("foo"))
+	assert.Equal("warn", logger.Type)
+
+	var open *CircuitOpenError
+	assert.True(errors.As(b.Allow("foo"), &open))
+	assert.Equal("foo", open.Host)
+
+	// other hosts are not affected
+	assert.Nil(b.Allow("bar"))
+	b.Record("bar", &http.Response{StatusCode: http.StatusOK}, nil)
+
+	time.Sleep(20 * time.Millisecond)
+	assert.Nil(b.Allow("foo"), "a probe is allowed once the timeout elapsed")
+	assert.Equal(StateHalfOpen, b.State("foo"))
+	assert.NotNil(b.Allow("foo"), "only one probe at a time")
+	b.Record("foo", &http.Response{StatusCode: http.StatusOK}, nil)
+	assert.Equal(StateClosed, b.State("foo"))
+
+	assert.Equal(map[string]BreakerState{"foo": StateClosed, "bar": StateClosed}, b.States())
+}
+
+func TestBreakerHalfOpenFailure(t *testing.T) {
+	assert := assert.New(t)
+	b := NewBreaker(nil, BreakerOptions{
+		FailureThreshold: 1,
+		OpenTimeout:      time.Millisecond,
+	})
+
+	assert.Nil(b.Allow("foo"))
+	b.Record("foo", nil, errors.New("foobar"))
+	time.Sleep(5 * time.Millisecond)
+
+	assert.Nil(b.Allow("foo"))
+	b.Record("foo", nil, errors.New("foobar"))
+	assert.Equal(StateOpen, b.State("foo"))
+}
+
+func TestBreakerClient(t *testing.T) {
+	assert := assert.New(t)
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	client := New(Options{
+		HTTPClient: &http.Client{},
+		Logger:     &logMock{},
+		Retries:    3,
+		Breaker:    NewBreaker(nil, BreakerOptions{FailureThreshold: 2, OpenTimeout: time.Minute}),
+	})
+	client.SetRetryWaitTime(time.Millisecond)
+
+	_, err := client.R().Get(ts.URL)
+
+	var open *CircuitOpenError
+	assert.True(errors.As(err, &open))
+	assert.Equal(2, calls, "retries stop as soon as the circuit opens")
+}
@@ -0,0 +1,168 @@
+package httpclient
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// BulkheadOptions bounds the requests sent to a single host
+type BulkheadOptions struct {
+	// MaxInFlight is the number of concurrent requests per host
+	MaxInFlight int
+	// MaxQueue is the number of requests per host waiting for a free slot, more
+	// requests fail immediately
+	MaxQueue int
+	// QueueTimeout is how long a request can wait for a free slot, zero means as
+	// long as the request context allows
+	QueueTimeout time.Duration
+}
+
+// BulkheadFullError is returned when a request can not get a slot for its host
+type BulkheadFullError struct {
+	Host   string
+	Reason string
+}
+
+func (e *BulkheadFullError) Error() string {
+	return fmt.Sprintf("httpclient: bulkhead full for host %s: %s", e.Host, e.Reason)
+}
+
+// BulkheadStats is a snapshot of the requests of a host
+type BulkheadStats struct {
+	InFlight int `json:"in_flight"`
+	Queued   int `json:"queued"`
+}
+
+type compartment struct {
+	slots  chan struct{}
+	queued int
+}
+
+// Bulkhead limits the in-flight requests per host so that a slow dependency can not
+// take every goroutine and connection
+type Bulkhead struct {
+	opts         BulkheadOptions
+	mu           sync.Mutex
+	compartments map[string]*compartment
+}
+
+func NewBulkhead(opts BulkheadOptions) *Bulkhead {
+	if opts.MaxInFlight <= 0 {
+		opts.MaxInFlight = 100
+	}
+	if opts.MaxQueue < 0 {
+		opts.MaxQueue = 0
+	}
+	return &Bulkhead{
+		opts:         opts,
+		compartments: map[string]*compartment{},
+	}
+}
+
+func (b *Bulkhead) compartment(host string) *compartment {
+	c, ok := b.compartments[host]
+	if !ok {
+		c = &compartment{slots: make(chan struct{}, b.opts.MaxInFlight)}
+		b.compartments[host] = c
+	}
+	return c
+}
+
+// Acquire takes a slot for host, waiting in the queue if needed. The returned
+// function releases the slot.
+func (b *Bulkhead) Acquire(req *http.Request) (func(), error) {
+	host := req.URL.Host
+
+	b.mu.Lock()
+	c := b.compartment(host)
+	select {
+	case c.slots <- struct{}{}:
+		b.mu.Unlock()
+		return b.releaser(c), nil
+	default:
+	}
+	if c.queued >= b.opts.MaxQueue {
+		b.mu.Unlock()
+		return nil, &BulkheadFullError{Host: host, Reason: "queue full"}
+	}
+	c.queued++
+	b.mu.Unlock()
+
+	defer func() {
+		b.mu.Lock()
+		c.queued--
+		b.mu.Unlock()
+	}()
+
+	var timeout <-chan time.Time
+	if b.opts.QueueTimeout > 0 {
+		timer := time.NewTimer(b.opts.QueueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case c.slots <- struct{}{}:
+		return b.releaser(c), nil
+	case <-timeout:
+		return nil, &BulkheadFullError{Host: host, Reason: "queue timeout"}
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+}
+
+func (b *Bulkhead) releaser(c *compartment) func() {
+	once := sync.Once{}
+	return func() {
+		once.Do(func() { <-c.slots })
+	}
+}
+
+// Stats returns the in-flight and queued requests of every known host
+func (b *Bulkhead) Stats() map[string]BulkheadStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ret := make(map[string]BulkheadStats, len(b.compartments))
+	for host, c := range b.compartments {
+		ret[host] = BulkheadStats{InFlight: len(c.slots), Queued: c.queued}
+	}
+	return ret
+}
+
+// releaseOnClose releases the slot once the response body is closed
+type releaseOnClose struct {
+	io.ReadCloser
+	release func()
+}
+
+func (r *releaseOnClose) Close() error {
+	err := r.ReadCloser.Close()
+	r.release()
+	return err
+}
+
+// BulkheadTransport defines a http.RoundTripper bounded by a Bulkhead. A request
+// holds its slot until its response body is closed.
+type BulkheadTransport struct {
+	T        http.RoundTripper
+	Bulkhead *Bulkhead
+}
+
+func (bt *BulkheadTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	release, err := bt.Bulkhead.Acquire(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := bt.T.RoundTrip(req)
+	if err != nil || resp.Body == nil {
+		release()
+		return resp, err
+	}
+	resp.Body = &releaseOnClose{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
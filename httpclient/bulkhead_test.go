@@ -0,0 +1,73 @@
+package httpclient_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/SpazioDati/go-utils/httpclient"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBulkhead(t *testing.T) {
+	assert := assert.New(t)
+	unblock := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-unblock
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+	host := strings.TrimPrefix(ts.URL, "http://")
+
+	bulkhead := NewBulkhead(BulkheadOptions{MaxInFlight: 1, MaxQueue: 1})
+	client := New(Options{
+		HTTPClient: &http.Client{},
+		Logger:     &logMock{},
+		Bulkhead:   bulkhead,
+	})
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := client.R().Get(ts.URL)
+			assert.Nil(err)
+		}()
+	}
+
+	assert.Eventually(func() bool {
+		return bulkhead.Stats()[host] == BulkheadStats{InFlight: 1, Queued: 1}
+	}, time.Second, time.Millisecond)
+
+	_, err := client.R().Get(ts.URL)
+	var full *BulkheadFullError
+	assert.True(errors.As(err, &full))
+	assert.Equal("queue full", full.Reason)
+
+	close(unblock)
+	wg.Wait()
+	assert.Equal(BulkheadStats{}, bulkhead.Stats()[host], "slots are released")
+}
+
+func TestBulkheadQueueTimeout(t *testing.T) {
+	assert := assert.New(t)
+	bulkhead := NewBulkhead(BulkheadOptions{MaxInFlight: 1, MaxQueue: 1, QueueTimeout: time.Millisecond})
+	req, _ := http.NewRequest("GET", "http://foo.bar/", nil)
+
+	release, err := bulkhead.Acquire(req)
+	assert.Nil(err)
+
+	_, err = bulkhead.Acquire(req)
+	var full *BulkheadFullError
+	assert.True(errors.As(err, &full))
+	assert.Equal("queue timeout", full.Reason)
+
+	release()
+	release()
+	assert.Equal(BulkheadStats{}, bulkhead.Stats()["foo.bar"], "releasing twice is harmless")
+}
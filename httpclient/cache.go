@@ -0,0 +1,492 @@
+package httpclient
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// CacheEntry is a response kept by a CacheStore, it must not be modified once stored
+type CacheEntry struct {
+	Status int
+	Header http.Header
+	Body   []byte
+	// Vary holds the request headers named by the Vary response header
+	Vary         http.Header
+	RequestTime  time.Time
+	ResponseTime time.Time
+}
+
+// CacheStore is the storage of a Cache
+type CacheStore interface {
+	Get(key string) (*CacheEntry, bool)
+	Set(key string, entry *CacheEntry)
+	Delete(key string)
+}
+
+// heuristicStatuses are the statuses cacheable without explicit freshness, RFC 7231 6.1
+var heuristicStatuses = map[int]bool{
+	200: true, 203: true, 204: true, 300: true, 301: true,
+	404: true, 405: true, 410: true, 414: true, 501: true,
+}
+
+// cacheControl parses the Cache-Control directives of h, names are lowercased
+func cacheControl(h http.Header) map[string]string {
+	cc := map[string]string{}
+	for _, value := range h.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			directive = strings.TrimSpace(directive)
+			if directive == "" {
+				continue
+			}
+			name, arg := directive, ""
+			if i := strings.Index(directive, "="); i >= 0 {
+				name, arg = directive[:i], strings.Trim(directive[i+1:], `"`)
+			}
+			cc[strings.ToLower(strings.TrimSpace(name))] = arg
+		}
+	}
+	return cc
+}
+
+// seconds parses a delta-seconds directive
+func seconds(cc map[string]string, name string) (time.Duration, bool) {
+	arg, ok := cc[name]
+	if !ok {
+		return 0, false
+	}
+	secs, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || secs < 0 {
+		return 0, false
+	}
+	return time.Duration(secs) * time.Second, true
+}
+
+func (e *CacheEntry) size() int64 {
+	size := int64(len(e.Body))
+	for _, h := range []http.Header{e.Header, e.Vary} {
+		for name, values := range h {
+			for _, v := range values {
+				size += int64(len(name) + len(v))
+			}
+		}
+	}
+	return size
+}
+
+func (e *CacheEntry) date() time.Time {
+	if date, err := http.ParseTime(e.Header.Get("Date")); err == nil {
+		return date
+	}
+	return e.ResponseTime
+}
+
+// lifetime is the freshness lifetime of the entry, RFC 7234 4.2.1
+func (e *CacheEntry) lifetime() time.Duration {
+	if maxAge, ok := seconds(cacheControl(e.Header), "max-age"); ok {
+		return maxAge
+	}
+	if expires := e.Header.Get("Expires"); expires != "" {
+		t, err := http.ParseTime(expires)
+		if err != nil {
+			return 0
+		}
+		return t.Sub(e.date())
+	}
+	if lastModified, err := http.ParseTime(e.Header.Get("Last-Modified")); err == nil && heuristicStatuses[e.Status] {
+		return e.date().Sub(lastModified) / 10
+	}
+	return 0
+}
+
+// age is the current age of the entry, RFC 7234 4.2.3
+func (e *CacheEntry) age(now time.Time) time.Duration {
+	apparent := e.ResponseTime.Sub(e.date())
+	if apparent < 0 {
+		apparent = 0
+	}
+	var age time.Duration
+	if secs, err := strconv.ParseInt(e.Header.Get("Age"), 10, 64); err == nil && secs > 0 {
+		age = time.Duration(secs) * time.Second
+	}
+	corrected := age + e.ResponseTime.Sub(e.RequestTime)
+	if corrected > apparent {
+		apparent = corrected
+	}
+	return apparent + now.Sub(e.ResponseTime)
+}
+
+func (e *CacheEntry) fresh(now time.Time) bool {
+	return e.lifetime() > e.age(now)
+}
+
+// staleIfError tells if the entry can be served when the origin fails, RFC 5861 4
+func (e *CacheEntry) staleIfError(reqCC map[string]string, now time.Time) bool {
+	cc := cacheControl(e.Header)
+	if _, ok := cc["must-revalidate"]; ok {
+		return false
+	}
+	staleness := e.age(now) - e.lifetime()
+	for _, directives := range []map[string]string{reqCC, cc} {
+		if limit, ok := seconds(directives, "stale-if-error"); ok && staleness <= limit {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *CacheEntry) varyMatches(req *http.Request) bool {
+	for name, values := range e.Vary {
+		if strings.Join(req.Header.Values(name), ",") != strings.Join(values, ",") {
+			return false
+		}
+	}
+	return true
+}
+
+// response builds a response out of the entry for req
+func (e *CacheEntry) response(req *http.Request, now time.Time) *http.Response {
+	header := e.Header.Clone()
+	header.Set("Age", strconv.FormatInt(int64(e.age(now)/time.Second), 10))
+	if !e.fresh(now) {
+		header.Add("Warning", `110 - "Response is Stale"`)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", e.Status, http.StatusText(e.Status)),
+		StatusCode:    e.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(e.Body)),
+		ContentLength: int64(len(e.Body)),
+		Request:       req,
+	}
+}
+
+// revalidated returns a copy of the entry updated with a 304 response
+func (e *CacheEntry) revalidated(header http.Header, requestTime, responseTime time.Time) *CacheEntry {
+	updated := *e
+	updated.Header = e.Header.Clone()
+	for name, values := range header {
+		if name == "Content-Length" {
+			continue
+		}
+		updated.Header[name] = values
+	}
+	updated.RequestTime = requestTime
+	updated.ResponseTime = responseTime
+	return &updated
+}
+
+type lruItem struct {
+	key   string
+	entry *CacheEntry
+	size  int64
+}
+
+// MemoryCacheStore is a CacheStore evicting the least recently used entries once
+// the stored bodies and headers exceed a number of bytes
+type MemoryCacheStore struct {
+	maxBytes int64
+	mu       sync.Mutex
+	size     int64
+	ll       *list.List
+	items    map[string]*list.Element
+}
+
+func NewMemoryCacheStore(maxBytes int64) *MemoryCacheStore {
+	return &MemoryCacheStore{
+		maxBytes: maxBytes,
+		ll:       list.New(),
+		items:    map[string]*list.Element{},
+	}
+}
+
+func (s *MemoryCacheStore) Get(key string) (*CacheEntry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	el, ok := s.items[key]
+	if !ok {
+		return nil, false
+	}
+	s.ll.MoveToFront(el)
+	return el.Value.(*lruItem).entry, true
+}
+
+func (s *MemoryCacheStore) Set(key string, entry *CacheEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.remove(key)
+	size := entry.size()
+	if size > s.maxBytes {
+		return
+	}
+	s.items[key] = s.ll.PushFront(&lruItem{key: key, entry: entry, size: size})
+	s.size += size
+	for s.size > s.maxBytes {
+		s.remove(s.ll.Back().Value.(*lruItem).key)
+	}
+}
+
+func (s *MemoryCacheStore) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remove(key)
+}
+
+func (s *MemoryCacheStore) remove(key string) {
+	el, ok := s.items[key]
+	if !ok {
+		return
+	}
+	s.ll.Remove(el)
+	delete(s.items, key)
+	s.size -= el.Value.(*lruItem).size
+}
+
+// MaxEntrySize returns the size of the largest entry the store keeps
+func (s *MemoryCacheStore) MaxEntrySize() int64 {
+	return s.maxBytes
+}
+
+// Len returns the number of stored entries and their size in bytes
+func (s *MemoryCacheStore) Len() (int, int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ll.Len(), s.size
+}
+
+// Cache outcomes, reported in the logs and in the http.cache span attribute
+const (
+	CacheHit         = "hit"
+	CacheMiss        = "miss"
+	CacheRevalidated = "revalidated"
+	CacheStale       = "stale"
+	CacheBypass      = "bypass"
+)
+
+// defaultMaxCachedBody bounds the bodies read for a store without MaxEntrySize
+const defaultMaxCachedBody = 10 << 20
+
+// Cache is an HTTP cache following RFC 7234, with the stale-if-error extension of
+// RFC 5861. It can be shared by callers with different credentials: as a shared
+// cache, it never stores private responses and stores the responses to requests with
+// credentials only when the origin allows it, keyed on those credentials.
+type Cache struct {
+	store  CacheStore
+	logger resty.Logger
+	// maxBody is the size of the largest body worth reading to store it
+	maxBody int64
+}
+
+// NewCache returns a Cache on store. Bodies larger than the MaxEntrySize of store, if
+// it has such a method, or than 10MB are streamed without being stored.
+func NewCache(logger resty.Logger, store CacheStore) *Cache {
+	maxBody := int64(defaultMaxCachedBody)
+	if sized, ok := store.(interface{ MaxEntrySize() int64 }); ok {
+		maxBody = sized.MaxEntrySize()
+	}
+	return &Cache{
+		store:   store,
+		logger:  logger,
+		maxBody: maxBody,
+	}
+}
+
+func (c *Cache) report(req *http.Request, outcome string) {
+	if c.logger != nil {
+		c.logger.Debugf("Cache %s for %s %s", outcome, req.Method, req.URL.String())
+	}
+	attemptSpan(req.Context()).SetAttributes(attribute.String("http.cache", outcome))
+}
+
+// sharedWithAuthorization tells if a response to a request with credentials can be
+// stored by a shared cache, RFC 7234 3.2. A response varying on Authorization can
+// as well, since it is only served to the same credentials.
+func sharedWithAuthorization(cc map[string]string, header http.Header) bool {
+	for _, directive := range []string{"public", "s-maxage", "must-revalidate"} {
+		if _, ok := cc[directive]; ok {
+			return true
+		}
+	}
+	for _, value := range header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(name), "Authorization") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// cacheKey keys the responses to requests with credentials on a hash of them, so
+// that they are only served to the same credentials
+func cacheKey(req *http.Request, credentials string) string {
+	if credentials == "" {
+		return req.URL.String()
+	}
+	sum := sha256.Sum256([]byte(credentials))
+	return req.URL.String() + " " + hex.EncodeToString(sum[:])
+}
+
+// save stores resp if it is cacheable and returns it with a fresh body
+func (c *Cache) save(req *http.Request, credentials string, resp *http.Response, reqCC map[string]string, requestTime time.Time) (*http.Response, error) {
+	cc := cacheControl(resp.Header)
+	_, noStore := cc["no-store"]
+	_, reqNoStore := reqCC["no-store"]
+	// a shared cache must not store private responses, RFC 7234 3
+	_, private := cc["private"]
+	if noStore || reqNoStore || private || !heuristicStatuses[resp.StatusCode] || resp.Header.Get("Vary") == "*" {
+		return resp, nil
+	}
+	if credentials != "" && !sharedWithAuthorization(cc, resp.Header) {
+		return resp, nil
+	}
+	if resp.ContentLength > c.maxBody {
+		return resp, nil
+	}
+
+	entry := &CacheEntry{
+		Status:       resp.StatusCode,
+		Header:       resp.Header.Clone(),
+		Vary:         http.Header{},
+		RequestTime:  requestTime,
+		ResponseTime: time.Now(),
+	}
+	if entry.lifetime() <= 0 && entry.Header.Get("ETag") == "" && entry.Header.Get("Last-Modified") == "" {
+		return resp, nil
+	}
+	for _, value := range resp.Header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			name = textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(name))
+			entry.Vary[name] = req.Header.Values(name)
+		}
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, c.maxBody+1))
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	if int64(len(body)) > c.maxBody {
+		// too large to store, the rest is streamed
+		resp.Body = &struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+		return resp, nil
+	}
+	resp.Body.Close()
+	entry.Body = body
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	c.store.Set(cacheKey(req, credentials), entry)
+	return resp, nil
+}
+
+// CacheTransport defines a http.RoundTripper serving GET requests from a Cache.
+// Successful unsafe requests invalidate the cached URL.
+type CacheTransport struct {
+	T     http.RoundTripper
+	Cache *Cache
+	// Credentials, when set, are added below by an OAuth2Transport: the requests are
+	// cached as if they had an Authorization header
+	Credentials *ClientCredentials
+}
+
+// credentials returns what authenticates req, empty if nothing does
+func (ct *CacheTransport) credentials(req *http.Request) string {
+	if auth := req.Header.Get("Authorization"); auth != "" {
+		return auth
+	}
+	if ct.Credentials != nil {
+		return "oauth2 " + ct.Credentials.identity()
+	}
+	return ""
+}
+
+func (ct *CacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	credentials := ct.credentials(req)
+	if req.Method != http.MethodGet {
+		resp, err := ct.T.RoundTrip(req)
+		if err == nil && resp.StatusCode < 400 && req.Method != http.MethodHead && req.Method != http.MethodOptions {
+			ct.Cache.store.Delete(cacheKey(req, ""))
+			if credentials != "" {
+				ct.Cache.store.Delete(cacheKey(req, credentials))
+			}
+		}
+		return resp, err
+	}
+
+	reqCC := cacheControl(req.Header)
+	// conditional requests of the caller are theirs to handle
+	if req.Header.Get("If-None-Match") != "" || req.Header.Get("If-Modified-Since") != "" {
+		ct.Cache.report(req, CacheBypass)
+		return ct.T.RoundTrip(req)
+	}
+
+	entry, ok := ct.Cache.store.Get(cacheKey(req, credentials))
+	ok = ok && entry.varyMatches(req)
+	now := time.Now()
+	if ok {
+		_, noCache := reqCC["no-cache"]
+		_, respNoCache := cacheControl(entry.Header)["no-cache"]
+		if !noCache && !respNoCache && entry.fresh(now) {
+			ct.Cache.report(req, CacheHit)
+			return entry.response(req, now), nil
+		}
+	}
+
+	outgoing := req
+	if ok {
+		etag, lastModified := entry.Header.Get("ETag"), entry.Header.Get("Last-Modified")
+		if etag != "" || lastModified != "" {
+			outgoing = req.Clone(req.Context())
+			if etag != "" {
+				outgoing.Header.Set("If-None-Match", etag)
+			}
+			if lastModified != "" {
+				outgoing.Header.Set("If-Modified-Since", lastModified)
+			}
+		}
+	}
+
+	resp, err := ct.T.RoundTrip(outgoing)
+	if ok && (err != nil || resp.StatusCode >= 500) && entry.staleIfError(reqCC, time.Now()) {
+		if resp != nil {
+			resp.Body.Close()
+		}
+		ct.Cache.report(req, CacheStale)
+		return entry.response(req, time.Now()), nil
+	}
+	if err != nil {
+		ct.Cache.report(req, CacheMiss)
+		return nil, err
+	}
+
+	if ok && resp.StatusCode == http.StatusNotModified && outgoing != req {
+		resp.Body.Close()
+		updated := entry.revalidated(resp.Header, now, time.Now())
+		ct.Cache.store.Set(cacheKey(req, credentials), updated)
+		ct.Cache.report(req, CacheRevalidated)
+		return updated.response(req, time.Now()), nil
+	}
+
+	ct.Cache.report(req, CacheMiss)
+	return ct.Cache.save(req, credentials, resp, reqCC, now)
+}
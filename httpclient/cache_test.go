@@ -0,0 +1,234 @@
+package httpclient_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/SpazioDati/go-utils/httpclient"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryCacheStore(t *testing.T) {
+	assert := assert.New(t)
+	store := NewMemoryCacheStore(25)
+
+	store.Set("a", &CacheEntry{Body: []byte("0123456789")})
+	store.Set("b", &CacheEntry{Body: []byte("0123456789")})
+	_, ok := store.Get("a")
+	assert.True(ok)
+
+	store.Set("c", &CacheEntry{Body: []byte("0123456789")})
+	_, ok = store.Get("b")
+	assert.False(ok, "least recently used is evicted")
+	_, ok = store.Get("a")
+	assert.True(ok)
+	count, size := store.Len()
+	assert.Equal(2, count)
+	assert.Equal(int64(20), size)
+
+	store.Set("big", &CacheEntry{Body: make([]byte, 30)})
+	_, ok = store.Get("big")
+	assert.False(ok, "entries larger than the store are not kept")
+}
+
+func TestCache(t *testing.T) {
+	assert := assert.New(t)
+	var calls int32
+	var failing int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		switch r.URL.Path {
+		case "/fresh":
+			w.Header().Set("Cache-Control", "max-age=60")
+		case "/etag":
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("Cache-Control", "no-cache, stale-if-error=60")
+			w.Header().Set("ETag", `"v1"`)
+		}
+		w.Write([]byte("payload"))
+	}))
+	defer ts.Close()
+
+	logger := &logMock{}
+	client := New(Options{
+		HTTPClient:     &http.Client{},
+		Logger:         logger,
+		DisableTracing: true,
+		Cache:          NewCache(logger, NewMemoryCacheStore(1<<20)),
+	})
+
+	for i := 0; i < 3; i++ {
+		resp, err := client.R().Get(ts.URL + "/fresh")
+		assert.Nil(err)
+		assert.Equal("payload", resp.String())
+	}
+	assert.Equal(int32(1), atomic.LoadInt32(&calls), "fresh responses are served from the cache")
+
+	_, err := client.R().Post(ts.URL + "/fresh")
+	assert.Nil(err)
+	_, err = client.R().Get(ts.URL + "/fresh")
+	assert.Nil(err)
+	assert.Equal(int32(3), atomic.LoadInt32(&calls), "unsafe requests invalidate the cache")
+
+	atomic.StoreInt32(&calls, 0)
+	for i := 0; i < 2; i++ {
+		resp, err := client.R().Get(ts.URL + "/etag")
+		assert.Nil(err)
+		assert.Equal(http.StatusOK, resp.StatusCode())
+		assert.Equal("payload", resp.String())
+	}
+	assert.Equal(int32(2), atomic.LoadInt32(&calls), "no-cache responses are revalidated")
+	assert.Equal("Cache %s for %s %s", logger.Format)
+	assert.Equal([]interface{}{CacheRevalidated, "GET", ts.URL + "/etag"}, logger.Values[0])
+
+	atomic.StoreInt32(&failing, 1)
+	resp, err := client.R().Get(ts.URL + "/etag")
+	assert.Nil(err)
+	assert.Equal(http.StatusOK, resp.StatusCode(), "stale content is served on error")
+	assert.Equal("payload", resp.String())
+
+	resp, err = client.R().Get(ts.URL + "/other")
+	assert.Nil(err)
+	assert.Equal(http.StatusServiceUnavailable, resp.StatusCode(), "nothing to serve")
+}
+
+func TestCacheSharing(t *testing.T) {
+	assert := assert.New(t)
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		switch r.URL.Path {
+		case "/varying":
+			w.Header().Set("Vary", "Authorization")
+		case "/marked-private":
+			w.Header().Set("Cache-Control", "private, max-age=60")
+		case "/big":
+			// chunked, no Content-Length
+			for i := 0; i < 4; i++ {
+				w.Write([]byte(strings.Repeat("x", 1000)))
+				w.(http.Flusher).Flush()
+			}
+			return
+		}
+		w.Write([]byte(r.Header.Get("Authorization")))
+	}))
+	defer ts.Close()
+
+	store := NewMemoryCacheStore(1000)
+	client := New(Options{
+		HTTPClient:     &http.Client{},
+		Logger:         &logMock{},
+		DisableTracing: true,
+		Cache:          NewCache(nil, store),
+	})
+	get := func(path, auth string) string {
+		resp, err := client.R().SetHeader("Authorization", auth).Get(ts.URL + path)
+		assert.Nil(err)
+		return resp.String()
+	}
+
+	assert.Equal("alice", get("/private", "alice"))
+	assert.Equal("bob", get("/private", "bob"), "responses to credentials are not shared")
+	assert.Equal(int32(2), atomic.SwapInt32(&calls, 0))
+
+	assert.Equal("alice", get("/varying", "alice"))
+	assert.Equal("alice", get("/varying", "alice"))
+	assert.Equal("bob", get("/varying", "bob"))
+	assert.Equal(int32(2), atomic.SwapInt32(&calls, 0), "responses varying on Authorization are stored")
+
+	assert.Equal("", get("/marked-private", ""))
+	assert.Equal("", get("/marked-private", ""))
+	assert.Equal(int32(2), atomic.SwapInt32(&calls, 0), "private responses are not stored")
+
+	for i := 0; i < 2; i++ {
+		assert.Equal(4000, len(get("/big", "")))
+	}
+	assert.Equal(int32(2), atomic.SwapInt32(&calls, 0), "bodies larger than the store are streamed")
+	count, _ := store.Len()
+	assert.Equal(2, count, "/varying, once per credentials")
+}
+
+func TestCacheOAuth2(t *testing.T) {
+	assert := assert.New(t)
+	tokens := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, _, _ := r.BasicAuth()
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token":"token-%s","token_type":"bearer","expires_in":3600}`, id)
+	}))
+	defer tokens.Close()
+	var calls int32
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if r.URL.Path == "/public" {
+			w.Header().Set("Cache-Control", "public, max-age=60")
+		} else {
+			w.Header().Set("Cache-Control", "max-age=60")
+		}
+		w.Write([]byte("data for " + r.Header.Get("Authorization")))
+	}))
+	defer api.Close()
+
+	cache := NewCache(nil, NewMemoryCacheStore(1000))
+	get := func(id, path string) string {
+		client := New(Options{
+			HTTPClient:     &http.Client{},
+			Logger:         &logMock{},
+			DisableTracing: true,
+			Cache:          cache,
+			OAuth2: NewClientCredentials(ClientCredentialsOptions{
+				TokenURL:     tokens.URL,
+				ClientID:     id,
+				ClientSecret: "secret",
+			}),
+		})
+		resp, err := client.R().Get(api.URL + path)
+		assert.Nil(err)
+		return resp.String()
+	}
+
+	assert.Equal("data for Bearer token-alice", get("alice", "/"))
+	assert.Equal("data for Bearer token-bob", get("bob", "/"))
+	assert.Equal("data for Bearer token-alice", get("alice", "/"))
+	assert.Equal(int32(3), atomic.SwapInt32(&calls, 0), "responses to credentials are not stored")
+
+	assert.Equal("data for Bearer token-alice", get("alice", "/public"))
+	assert.Equal("data for Bearer token-bob", get("bob", "/public"))
+	assert.Equal("data for Bearer token-alice", get("alice", "/public"))
+	assert.Equal(int32(2), atomic.SwapInt32(&calls, 0), "shared responses are kept per credentials")
+}
+
+func TestCacheFreshness(t *testing.T) {
+	assert := assert.New(t)
+	store := NewMemoryCacheStore(1 << 20)
+	now := time.Now()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Expires", now.Add(-time.Minute).UTC().Format(http.TimeFormat))
+		w.Header().Set("Date", now.UTC().Format(http.TimeFormat))
+		w.Write([]byte("expired"))
+	}))
+	defer ts.Close()
+
+	client := New(Options{
+		HTTPClient:     &http.Client{},
+		Logger:         &logMock{},
+		DisableTracing: true,
+		Cache:          NewCache(nil, store),
+	})
+	_, err := client.R().Get(ts.URL)
+	assert.Nil(err)
+	count, _ := store.Len()
+	assert.Zero(count, "responses without freshness nor validators are not stored")
+}
@@ -1,44 +1,215 @@
 package httpclient
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-resty/resty/v2"
+	oteltrace "go.opentelemetry.io/otel/trace"
 )
 
-var traceEnabled = false
-
 func New(opts Options) *resty.Client {
-	return resty.
-		NewWithClient(opts.HTTPClient).
+	policy := DefaultRetryPolicy()
+	if opts.RetryPolicy != nil {
+		policy = *opts.RetryPolicy
+	}
+
+	// resty sets the transports on the *http.Client itself, the one of the options
+	// may be shared with other clients or be http.DefaultClient
+	hc := *opts.HTTPClient
+	client := resty.
+		NewWithClient(&hc).
 		SetLogger(opts.Logger).
 		SetTimeout(opts.Timeout).
 		SetRetryCount(opts.Retries).
 		SetHeader("User-Agent", opts.UserAgent).
-		AddRetryCondition(RetryCondition()).
+		AddRetryCondition(policy.Condition()).
+		AddRetryHook(closeRetriedBody).
+		OnBeforeRequest(IdempotencyKey()).
 		OnBeforeRequest(OnBeforeRequest(opts.Logger)).
 		OnAfterResponse(OnAfterResponse(opts.Logger)).
 		OnError(OnError(opts.Logger))
+
+	// before the transport gets wrapped, the TLS dialer goes through the resolver
+	if opts.Resolver != nil {
+		opts.Resolver.apply(client)
+	}
+	if opts.TLS != nil {
+		opts.TLS.apply(client)
+	}
+
+	// before tracing, which starts every attempt from the context of the first one
+	if opts.Balancer != nil {
+		if client.HostURL == "" {
+			client.SetHostURL(opts.Balancer.BaseURL())
+		}
+		client.OnBeforeRequest(opts.Balancer.OnBeforeRequest())
+	}
+
+	var tracer oteltrace.Tracer
+	if opts.TracerProvider != nil {
+		tracer = opts.TracerProvider.Tracer(instrumentationName)
+	}
+	if !opts.DisableTracing {
+		client.
+			OnBeforeRequest(propagateTracing(tracer)).
+			AddRetryHook(EndSpanOnRetry()).
+			OnError(EndSpanOnError())
+	}
+
+	if opts.RateLimiter != nil {
+		client.OnBeforeRequest(opts.RateLimiter.OnBeforeRequest())
+	}
+
+	// after the rate limiter wait, which eats into the deadline
+	client.OnBeforeRequest(PropagateDeadline())
+
+	bodyLogger := DefaultBodyLogger
+	if opts.BodyLogger != nil {
+		bodyLogger = opts.BodyLogger
+	}
+	client.
+		OnAfterResponse(bodyLogger.OnAfterResponse(opts.Logger)).
+		OnError(bodyLogger.OnError(opts.Logger))
+
+	if opts.Hedger != nil {
+		client.OnBeforeRequest(opts.Hedger.OnBeforeRequest())
+	}
+
+	if opts.Backoff != nil {
+		opts.Backoff.apply(client)
+	}
+
+	if opts.Metrics != nil {
+		client.
+			EnableTrace().
+			OnAfterResponse(opts.Metrics.OnAfterResponse()).
+			OnError(opts.Metrics.OnError())
+	}
+
+	// a rejected token is replaced below every other transport, so a 401 retry
+	// is a single attempt for them
+	if opts.OAuth2 != nil {
+		client.SetTransport(&OAuth2Transport{
+			T:           client.GetClient().Transport,
+			Credentials: opts.OAuth2,
+			Tracer:      tracer,
+		})
+	}
+
+	// injected faults go through every other transport like real ones
+	faultInjector := DefaultFaultInjector
+	if opts.FaultInjector != nil {
+		faultInjector = opts.FaultInjector
+	}
+	client.SetTransport(&FaultTransport{
+		T:        client.GetClient().Transport,
+		Injector: faultInjector,
+		Logger:   opts.Logger,
+	})
+
+	// the breaker counts the timeouts as failures, injected latency counts too
+	if opts.AdaptiveTimeouts != nil {
+		client.
+			EnableTrace().
+			OnAfterResponse(opts.AdaptiveTimeouts.OnAfterResponse()).
+			SetTransport(&AdaptiveTimeoutTransport{
+				T:        client.GetClient().Transport,
+				Timeouts: opts.AdaptiveTimeouts,
+			})
+	}
+
+	if opts.Breaker != nil {
+		client.SetTransport(&BreakerTransport{
+			T:       client.GetClient().Transport,
+			Breaker: opts.Breaker,
+		})
+	}
+
+	if opts.Bulkhead != nil {
+		client.SetTransport(&BulkheadTransport{
+			T:        client.GetClient().Transport,
+			Bulkhead: opts.Bulkhead,
+		})
+	}
+
+	// the bulkhead and the breaker guard each endpoint on its own
+	if opts.Balancer != nil {
+		client.SetTransport(&BalancerTransport{
+			T:        client.GetClient().Transport,
+			Balancer: opts.Balancer,
+		})
+	}
+
+	// every hedged attempt goes through the balancer, the bulkhead and the breaker
+	if opts.Hedger != nil {
+		client.SetTransport(&HedgeTransport{
+			T:      client.GetClient().Transport,
+			Hedger: opts.Hedger,
+		})
+	}
+
+	// cache hits never reach the network
+	if opts.Cache != nil {
+		client.SetTransport(&CacheTransport{
+			T:     client.GetClient().Transport,
+			Cache: opts.Cache,
+			// the token is added below, by the OAuth2Transport
+			Credentials: opts.OAuth2,
+		})
+	}
+
+	// every error of the transports above is classified
+	client.SetTransport(&ErrorTransport{T: client.GetClient().Transport})
+
+	// the span must outlive every other response middleware, the responses which are
+	// not parsed skip them and end it with their body
+	if !opts.DisableTracing {
+		client.
+			OnAfterResponse(EndSpan()).
+			SetTransport(&SpanTransport{T: client.GetClient().Transport})
+	}
+
+	return client
 }
 
+// EnableTrace traces every request for timeout, see DefaultTraceRegistry for scoped sessions
 func EnableTrace(timeout time.Duration) {
-	traceEnabled = true
-	go func(timeout time.Duration) {
-		time.Sleep(timeout)
-		traceEnabled = false
-	}(timeout)
+	DefaultTraceRegistry.Enable(TraceScope{}, timeout)
+}
+
+// DisableTrace stops every trace session
+func DisableTrace() {
+	DefaultTraceRegistry.DisableAll()
 }
 
 func IsTraceEnabled() bool {
-	return traceEnabled
+	return DefaultTraceRegistry.Enabled()
+}
+
+// ownTransport replaces the *http.Transport of client with a copy which can be
+// modified. New builds client on a copy of Options.HTTPClient, so neither the
+// client nor the transport of the options are changed.
+func ownTransport(client *resty.Client, logger resty.Logger) (*http.Transport, bool) {
+	transport, ok := client.GetClient().Transport.(*http.Transport)
+	if !ok {
+		if logger != nil {
+			logger.Warnf("An *http.Transport is needed, got %T", client.GetClient().Transport)
+		}
+		return nil, false
+	}
+	transport = transport.Clone()
+	client.SetTransport(transport)
+	return transport, true
 }
 
 func OnBeforeRequest(logger resty.Logger) resty.RequestMiddleware {
 	return func(c *resty.Client, r *resty.Request) error {
-		if !IsTraceEnabled() {
+		withURLTemplate(r)
+		if !DefaultTraceRegistry.MatchRequest(c, r) {
 			return nil
 		}
 
@@ -49,13 +220,21 @@ func OnBeforeRequest(logger resty.Logger) resty.RequestMiddleware {
 
 func OnAfterResponse(logger resty.Logger) resty.ResponseMiddleware {
 	return func(c *resty.Client, r *resty.Response) error {
-		return Doer(logger, r.Request.TraceInfo())
+		if !DefaultTraceRegistry.MatchRequest(c, r.Request) {
+			return nil
+		}
+		DefaultTraceBuffer.Add(NewTraceRecord(r.Request, r.StatusCode(), nil))
+		return logTraceInfo(logger, r.Request.TraceInfo())
 	}
 }
 
 func OnError(logger resty.Logger) resty.ErrorHook {
 	return func(r *resty.Request, err error) {
-		_ = Doer(logger, r.TraceInfo())
+		if !DefaultTraceRegistry.MatchRequest(nil, r) {
+			return
+		}
+		DefaultTraceBuffer.Add(NewTraceRecord(r, 0, err))
+		_ = logTraceInfo(logger, r.TraceInfo())
 	}
 }
 
@@ -63,7 +242,10 @@ func Doer(logger resty.Logger, ti resty.TraceInfo) (err error) {
 	if !IsTraceEnabled() {
 		return
 	}
+	return logTraceInfo(logger, ti)
+}
 
+func logTraceInfo(logger resty.Logger, ti resty.TraceInfo) (err error) {
 	hash := map[string]interface{}{
 		"DNSLookup":      ti.DNSLookup,
 		"ConnTime":       ti.ConnTime,
@@ -85,22 +267,46 @@ func Doer(logger resty.Logger, ti resty.TraceInfo) (err error) {
 	return
 }
 
+// Deprecated: RetryCondition retries whatever the method, New uses RetryPolicy.Condition
 func RetryCondition() resty.RetryConditionFunc {
 	return func(r *resty.Response, err error) bool {
+		// retrying against an open circuit would just fail again
+		var open *CircuitOpenError
+		if errors.As(err, &open) {
+			return false
+		}
 		return err != nil || r.StatusCode() >= http.StatusInternalServerError
 	}
 }
 
+// TraceEnablerMW starts a trace session for "to" minutes (default 15). The session can
+// be scoped with the "host", "path", "header" and "value" query parameters, see TraceScope.
 func TraceEnablerMW(logger resty.Logger) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		to := 15 * time.Minute
-		if timeout, ok := c.GetQuery("to"); ok {
-			if converted, err := strconv.Atoi(timeout); err == nil {
-				to = time.Duration(converted) * time.Minute
-			}
-		}
-		logger.Warnf("Enabling resty trace for %d minutes", int(to.Minutes()))
-		EnableTrace(to)
+		to, scope := sessionTimeout(c), sessionScope(c)
+		logger.Warnf("Enabling resty trace for %d minutes on %s", int(to.Minutes()), scope)
+		DefaultTraceRegistry.Enable(scope, to)
 		c.String(http.StatusOK, "OK")
 	}
 }
+
+// sessionTimeout reads the "to" query parameter of the enabler handlers, in minutes,
+// defaulting to 15
+func sessionTimeout(c *gin.Context) time.Duration {
+	if timeout, ok := c.GetQuery("to"); ok {
+		if converted, err := strconv.Atoi(timeout); err == nil {
+			return time.Duration(converted) * time.Minute
+		}
+	}
+	return 15 * time.Minute
+}
+
+// sessionScope reads the TraceScope query parameters of the enabler handlers
+func sessionScope(c *gin.Context) TraceScope {
+	return TraceScope{
+		Host:        c.Query("host"),
+		PathPrefix:  c.Query("path"),
+		Header:      c.Query("header"),
+		HeaderValue: c.Query("value"),
+	}
+}
@@ -65,15 +65,17 @@ func TestEnableTrace(t *testing.T) {
 
 	assert.False(IsTraceEnabled())
 
-	EnableTrace(0 * time.Millisecond)
+	EnableTrace(50 * time.Millisecond)
 	assert.True(IsTraceEnabled())
-	done := make(chan bool)
-	go func() {
-		time.Sleep(1 * time.Millisecond)
-		assert.False(IsTraceEnabled())
-		done <- true
-	}()
-	assert.True(<-done)
+	EnableTrace(200 * time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	assert.True(IsTraceEnabled(), "an expired session does not cut a longer one short")
+	time.Sleep(150 * time.Millisecond)
+	assert.False(IsTraceEnabled())
+
+	EnableTrace(time.Minute)
+	DisableTrace()
+	assert.False(IsTraceEnabled())
 }
 
 func TestOnAfterResponse(t *testing.T) {
@@ -86,7 +88,8 @@ func TestOnAfterResponse(t *testing.T) {
 	}))
 	assert.Empty(mock)
 
-	EnableTrace(0 * time.Millisecond)
+	EnableTrace(time.Minute)
+	defer DisableTrace()
 	assert.Nil(fn(&resty.Client{}, &resty.Response{
 		Request: &resty.Request{},
 	}))
@@ -101,11 +104,13 @@ func TestOnBeforeRequest(t *testing.T) {
 
 	assert.Nil(fn(&resty.Client{}, request))
 
-	EnableTrace(0 * time.Millisecond)
+	EnableTrace(time.Minute)
+	defer DisableTrace()
 	assert.Nil(fn(&resty.Client{}, request))
 }
 
 func TestTraceEnablerMW(t *testing.T) {
+	defer DisableTrace()
 	for _, test := range []struct {
 		in  string
 		out string
@@ -0,0 +1,253 @@
+package httpclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/SpazioDati/go-utils/internal/configfile"
+	"github.com/go-resty/resty/v2"
+)
+
+// Duration is a time.Duration written as "1.5s" in config files
+type Duration time.Duration
+
+func parseDuration(value string) (Duration, error) {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+	return Duration(d), nil
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) (err error) {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("invalid duration %s, expected a string like \"1.5s\"", data)
+	}
+	*d, err = parseDuration(value)
+	return err
+}
+
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) (err error) {
+	var value string
+	if err := unmarshal(&value); err != nil {
+		return err
+	}
+	*d, err = parseDuration(value)
+	return err
+}
+
+// Config is the serializable form of Options, see LoadOptions
+type Config struct {
+	Timeout        Duration        `json:"timeout" yaml:"timeout"`
+	Retries        int             `json:"retries" yaml:"retries"`
+	UserAgent      string          `json:"user_agent" yaml:"user_agent"`
+	DisableTracing bool            `json:"disable_tracing" yaml:"disable_tracing"`
+	Backoff        *BackoffConfig  `json:"backoff" yaml:"backoff"`
+	Breaker        *BreakerConfig  `json:"breaker" yaml:"breaker"`
+	RateLimit      *RateConfig     `json:"rate_limit" yaml:"rate_limit"`
+	Bulkhead       *BulkheadConfig `json:"bulkhead" yaml:"bulkhead"`
+}
+
+type BackoffConfig struct {
+	BaseDelay     Duration `json:"base_delay" yaml:"base_delay"`
+	MaxDelay      Duration `json:"max_delay" yaml:"max_delay"`
+	Multiplier    float64  `json:"multiplier" yaml:"multiplier"`
+	Jitter        string   `json:"jitter" yaml:"jitter"`
+	MaxRetryAfter Duration `json:"max_retry_after" yaml:"max_retry_after"`
+}
+
+type BreakerConfig struct {
+	FailureThreshold int      `json:"failure_threshold" yaml:"failure_threshold"`
+	OpenTimeout      Duration `json:"open_timeout" yaml:"open_timeout"`
+	HalfOpenRequests int      `json:"half_open_requests" yaml:"half_open_requests"`
+}
+
+type RateConfig struct {
+	RPS   float64              `json:"rps" yaml:"rps"`
+	Burst int                  `json:"burst" yaml:"burst"`
+	Wait  bool                 `json:"wait" yaml:"wait"`
+	Hosts map[string]RateLimit `json:"hosts" yaml:"hosts"`
+}
+
+type BulkheadConfig struct {
+	MaxInFlight  int      `json:"max_in_flight" yaml:"max_in_flight"`
+	MaxQueue     int      `json:"max_queue" yaml:"max_queue"`
+	QueueTimeout Duration `json:"queue_timeout" yaml:"queue_timeout"`
+}
+
+// DefaultConfig is the base every loaded Config starts from
+func DefaultConfig() Config {
+	return Config{
+		Timeout:   Duration(30 * time.Second),
+		Retries:   2,
+		UserAgent: "go-utils/httpclient",
+	}
+}
+
+// ConfigError lists every invalid value of a Config
+type ConfigError struct {
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	return configfile.Message("httpclient", e.Problems)
+}
+
+var jitters = map[string]Jitter{
+	"":             FullJitter,
+	"full":         FullJitter,
+	"decorrelated": DecorrelatedJitter,
+	"none":         NoJitter,
+}
+
+// Validate returns a *ConfigError if any value is out of range
+func (c Config) Validate() error {
+	problems := configfile.Problems{}
+	check := problems.Check
+
+	check(c.Timeout >= 0, "timeout must not be negative, got %s", time.Duration(c.Timeout))
+	check(c.Retries >= 0, "retries must not be negative, got %d", c.Retries)
+	if b := c.Backoff; b != nil {
+		check(b.BaseDelay >= 0, "backoff.base_delay must not be negative, got %s", time.Duration(b.BaseDelay))
+		check(b.MaxDelay >= 0, "backoff.max_delay must not be negative, got %s", time.Duration(b.MaxDelay))
+		check(b.MaxDelay == 0 || b.MaxDelay >= b.BaseDelay, "backoff.max_delay %s is lower than base_delay %s", time.Duration(b.MaxDelay), time.Duration(b.BaseDelay))
+		check(b.Multiplier == 0 || b.Multiplier >= 1, "backoff.multiplier must be at least 1, got %g", b.Multiplier)
+		_, ok := jitters[b.Jitter]
+		check(ok, "backoff.jitter must be one of full, decorrelated or none, got %q", b.Jitter)
+		check(b.MaxRetryAfter >= 0, "backoff.max_retry_after must not be negative, got %s", time.Duration(b.MaxRetryAfter))
+	}
+	if b := c.Breaker; b != nil {
+		check(b.FailureThreshold >= 0, "breaker.failure_threshold must not be negative, got %d", b.FailureThreshold)
+		check(b.OpenTimeout >= 0, "breaker.open_timeout must not be negative, got %s", time.Duration(b.OpenTimeout))
+		check(b.HalfOpenRequests >= 0, "breaker.half_open_requests must not be negative, got %d", b.HalfOpenRequests)
+	}
+	if r := c.RateLimit; r != nil {
+		check(r.RPS >= 0, "rate_limit.rps must not be negative, got %g", r.RPS)
+		check(r.Burst >= 0, "rate_limit.burst must not be negative, got %d", r.Burst)
+	}
+	if b := c.Bulkhead; b != nil {
+		check(b.MaxInFlight >= 0, "bulkhead.max_in_flight must not be negative, got %d", b.MaxInFlight)
+		check(b.MaxQueue >= 0, "bulkhead.max_queue must not be negative, got %d", b.MaxQueue)
+		check(b.QueueTimeout >= 0, "bulkhead.queue_timeout must not be negative, got %s", time.Duration(b.QueueTimeout))
+	}
+
+	if len(problems) > 0 {
+		return &ConfigError{Problems: problems}
+	}
+	return nil
+}
+
+// Options builds the Options described by c, the Breaker logs through logger
+func (c Config) Options(logger resty.Logger) (Options, error) {
+	if err := c.Validate(); err != nil {
+		return Options{}, err
+	}
+
+	opts := Options{
+		HTTPClient:     &http.Client{},
+		Logger:         logger,
+		Timeout:        time.Duration(c.Timeout),
+		Retries:        c.Retries,
+		UserAgent:      c.UserAgent,
+		DisableTracing: c.DisableTracing,
+	}
+	if b := c.Backoff; b != nil {
+		opts.Backoff = &BackoffPolicy{
+			BaseDelay:     time.Duration(b.BaseDelay),
+			MaxDelay:      time.Duration(b.MaxDelay),
+			Multiplier:    b.Multiplier,
+			Jitter:        jitters[b.Jitter],
+			MaxRetryAfter: time.Duration(b.MaxRetryAfter),
+		}
+	}
+	if b := c.Breaker; b != nil {
+		opts.Breaker = NewBreaker(logger, BreakerOptions{
+			FailureThreshold: b.FailureThreshold,
+			OpenTimeout:      time.Duration(b.OpenTimeout),
+			HalfOpenRequests: b.HalfOpenRequests,
+		})
+	}
+	if r := c.RateLimit; r != nil {
+		opts.RateLimiter = NewRateLimiter(RateLimiterOptions{
+			Default: RateLimit{RPS: r.RPS, Burst: r.Burst},
+			Hosts:   r.Hosts,
+			Wait:    r.Wait,
+		})
+	}
+	if b := c.Bulkhead; b != nil {
+		opts.Bulkhead = NewBulkhead(BulkheadOptions{
+			MaxInFlight:  b.MaxInFlight,
+			MaxQueue:     b.MaxQueue,
+			QueueTimeout: time.Duration(b.QueueTimeout),
+		})
+	}
+	return opts, nil
+}
+
+// DecodeFile reads a YAML or JSON file, chosen by extension, over c
+func (c *Config) DecodeFile(path string) error {
+	return configfile.DecodeFile("httpclient", path, c)
+}
+
+// Environment variables read by DecodeEnv, after the prefix
+const (
+	EnvTimeout        = "TIMEOUT"
+	EnvRetries        = "RETRIES"
+	EnvUserAgent      = "USER_AGENT"
+	EnvDisableTracing = "DISABLE_TRACING"
+)
+
+// DecodeEnv overrides c with the environment variables named prefix followed by
+// EnvTimeout, EnvRetries, EnvUserAgent or EnvDisableTracing, e.g. HTTPCLIENT_RETRIES.
+// Only these top level settings can come from the environment, the backoff, breaker,
+// rate_limit and bulkhead sections are read from the file only.
+func (c *Config) DecodeEnv(prefix string) error {
+	problems := configfile.Problems{}
+	if value, ok := os.LookupEnv(prefix + EnvTimeout); ok {
+		d, err := parseDuration(value)
+		if err != nil {
+			problems.Add("%s: %v", prefix+EnvTimeout, err)
+		}
+		c.Timeout = d
+	}
+	if value, ok := os.LookupEnv(prefix + EnvRetries); ok {
+		retries, err := strconv.Atoi(value)
+		if err != nil {
+			problems.Add("%s: invalid integer %q", prefix+EnvRetries, value)
+		}
+		c.Retries = retries
+	}
+	if value, ok := os.LookupEnv(prefix + EnvUserAgent); ok {
+		c.UserAgent = value
+	}
+	if value, ok := os.LookupEnv(prefix + EnvDisableTracing); ok {
+		disable, err := strconv.ParseBool(value)
+		if err != nil {
+			problems.Add("%s: invalid boolean %q", prefix+EnvDisableTracing, value)
+		}
+		c.DisableTracing = disable
+	}
+
+	if len(problems) > 0 {
+		return &ConfigError{Problems: problems}
+	}
+	return nil
+}
+
+// LoadOptions builds Options from DefaultConfig, then the file at path if not empty,
+// then the environment variables starting with "HTTPCLIENT_", see DecodeEnv
+func LoadOptions(path string, logger resty.Logger) (Options, error) {
+	config := DefaultConfig()
+	err := configfile.Load(path, config.DecodeFile, func() error {
+		return config.DecodeEnv("HTTPCLIENT_")
+	})
+	if err != nil {
+		return Options{}, err
+	}
+	return config.Options(logger)
+}
@@ -0,0 +1,94 @@
+package httpclient_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/SpazioDati/go-utils/httpclient"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeConfig(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadOptions(t *testing.T) {
+	assert := assert.New(t)
+
+	opts, err := LoadOptions("", &logMock{})
+	assert.Nil(err)
+	assert.Equal(30*time.Second, opts.Timeout)
+	assert.Equal(2, opts.Retries)
+	assert.NotNil(opts.HTTPClient)
+	assert.Nil(opts.Breaker)
+
+	path := writeConfig(t, "client.yaml", `
+timeout: 5s
+retries: 4
+user_agent: foo/1.0
+backoff:
+  base_delay: 50ms
+  jitter: decorrelated
+breaker:
+  failure_threshold: 3
+rate_limit:
+  rps: 10
+  hosts:
+    api.foo.bar: {rps: 1, burst: 2}
+`)
+	os.Setenv("HTTPCLIENT_RETRIES", "1")
+	defer os.Unsetenv("HTTPCLIENT_RETRIES")
+
+	opts, err = LoadOptions(path, &logMock{})
+	assert.Nil(err)
+	assert.Equal(5*time.Second, opts.Timeout)
+	assert.Equal(1, opts.Retries, "the environment wins over the file")
+	assert.Equal("foo/1.0", opts.UserAgent)
+	assert.Equal(&BackoffPolicy{BaseDelay: 50 * time.Millisecond, Jitter: DecorrelatedJitter}, opts.Backoff)
+	assert.NotNil(opts.Breaker)
+	assert.NotNil(opts.RateLimiter)
+	assert.Nil(opts.Bulkhead)
+
+	path = writeConfig(t, "client.json", `{"timeout": "2s", "bulkhead": {"max_in_flight": 5}}`)
+	opts, err = LoadOptions(path, &logMock{})
+	assert.Nil(err)
+	assert.Equal(2*time.Second, opts.Timeout)
+	assert.NotNil(opts.Bulkhead)
+}
+
+func TestLoadOptionsErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	for name, content := range map[string]string{
+		"duration.yaml": "timeout: 5 seconds",
+		"duration.json": `{"timeout": 5}`,
+		"unknown.yaml":  "timeuot: 5s",
+		"config.toml":   "timeout = '5s'",
+	} {
+		_, err := LoadOptions(writeConfig(t, name, content), &logMock{})
+		assert.NotNil(err, name)
+	}
+
+	path := writeConfig(t, "client.yaml", "retries: -1\nbackoff: {jitter: random, multiplier: 0.5}")
+	_, err := LoadOptions(path, &logMock{})
+	var configErr *ConfigError
+	assert.True(errors.As(err, &configErr))
+	assert.Equal([]string{
+		"retries must not be negative, got -1",
+		"backoff.multiplier must be at least 1, got 0.5",
+		`backoff.jitter must be one of full, decorrelated or none, got "random"`,
+	}, configErr.Problems)
+
+	os.Setenv("HTTPCLIENT_TIMEOUT", "forever")
+	defer os.Unsetenv("HTTPCLIENT_TIMEOUT")
+	_, err = LoadOptions("", &logMock{})
+	assert.EqualError(err, `httpclient: invalid config: HTTPCLIENT_TIMEOUT: invalid duration "forever"`)
+}
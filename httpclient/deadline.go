@@ -0,0 +1,30 @@
+package httpclient
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/SpazioDati/go-utils/propagator"
+	"github.com/go-resty/resty/v2"
+)
+
+// PropagateDeadline returns a middleware sending the time left to the request
+// context deadline in the propagator.HDRRequestDeadline header, so the server can
+// give up when the caller does. It is computed again for each attempt, requests
+// without a deadline are left untouched.
+func PropagateDeadline() resty.RequestMiddleware {
+	return func(c *resty.Client, r *resty.Request) error {
+		deadline, ok := r.Context().Deadline()
+		if !ok {
+			r.Header.Del(propagator.HDRRequestDeadline)
+			return nil
+		}
+
+		left := time.Until(deadline).Milliseconds()
+		if left < 0 {
+			left = 0
+		}
+		r.SetHeader(propagator.HDRRequestDeadline, strconv.FormatInt(left, 10))
+		return nil
+	}
+}
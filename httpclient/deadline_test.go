@@ -0,0 +1,54 @@
+package httpclient_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	. "github.com/SpazioDati/go-utils/httpclient"
+	"github.com/SpazioDati/go-utils/opentelemetry"
+	"github.com/SpazioDati/go-utils/propagator"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPropagateDeadline(t *testing.T) {
+	assert := assert.New(t)
+
+	r := gin.New()
+	r.Use(opentelemetry.DeadlineMW())
+	r.GET("/", func(c *gin.Context) {
+		deadline, ok := c.Request.Context().Deadline()
+		if !ok {
+			c.String(http.StatusOK, "none")
+			return
+		}
+		c.String(http.StatusOK, strconv.FormatInt(time.Until(deadline).Milliseconds(), 10))
+	})
+	r.GET("/header", func(c *gin.Context) {
+		c.String(http.StatusOK, c.GetHeader(propagator.HDRRequestDeadline))
+	})
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	client := New(Options{
+		HTTPClient:     &http.Client{},
+		Logger:         &logMock{},
+		DisableTracing: true,
+	})
+
+	resp, err := client.R().Get(ts.URL + "/header")
+	assert.Nil(err)
+	assert.Equal("", resp.String(), "no deadline, no header")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	resp, err = client.R().SetContext(ctx).Get(ts.URL)
+	assert.Nil(err)
+	left, err := strconv.Atoi(resp.String())
+	assert.Nil(err)
+	assert.True(left > 1500 && left <= 2000, "the server shares the deadline: %d", left)
+}
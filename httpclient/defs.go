@@ -5,12 +5,55 @@ import (
 	"time"
 
 	"github.com/go-resty/resty/v2"
+	oteltrace "go.opentelemetry.io/otel/trace"
 )
 
 type Options struct {
+	// HTTPClient is copied by New, the transports are set on the copy only
 	HTTPClient *http.Client
 	Logger     resty.Logger
 	Timeout    time.Duration
 	Retries    int
 	UserAgent  string
+	// Breaker, when set, guards every host with a circuit breaker
+	Breaker *Breaker
+	// Backoff, when set, replaces the default wait between retries
+	Backoff *BackoffPolicy
+	// RetryPolicy decides which attempts are retried, defaults to DefaultRetryPolicy
+	RetryPolicy *RetryPolicy
+	// Metrics, when set, traces every request and records its connection timings
+	Metrics *Metrics
+	// DisableTracing stops New from starting a span and propagating the tracing
+	// headers for each request
+	DisableTracing bool
+	// TracerProvider starts the client spans, defaults to the tracer set up by
+	// opentelemetry.Init
+	TracerProvider oteltrace.TracerProvider
+	// RateLimiter, when set, throttles every attempt
+	RateLimiter *RateLimiter
+	// Bulkhead, when set, bounds the concurrent requests per host
+	Bulkhead *Bulkhead
+	// Hedger, when set, sends a second attempt for GET and HEAD requests slower than
+	// its delay and keeps the first response
+	Hedger *Hedger
+	// Cache, when set, serves GET requests from an RFC 7234 cache
+	Cache *Cache
+	// BodyLogger logs the redacted bodies while enabled, defaults to DefaultBodyLogger
+	BodyLogger *BodyLogger
+	// FaultInjector injects the faults enabled on it, defaults to DefaultFaultInjector
+	FaultInjector *FaultInjector
+	// OAuth2, when set, authenticates every request with a client credentials token
+	OAuth2 *ClientCredentials
+	// TLS, when set, configures the TLS connections of HTTPClient, which must use
+	// an *http.Transport
+	TLS *TLSReloader
+	// Balancer, when set, spreads the requests to its endpoints, relative URLs are
+	// resolved against its first endpoint
+	Balancer *Balancer
+	// AdaptiveTimeouts, when set, bounds the wait for the response headers of each
+	// host or route by its observed latency
+	AdaptiveTimeouts *AdaptiveTimeouts
+	// Resolver, when set, caches the DNS lookups of HTTPClient, which must use an
+	// *http.Transport
+	Resolver *Resolver
 }
@@ -0,0 +1,334 @@
+package httpclient
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// DownloadOptions configures Download and DownloadFile
+type DownloadOptions struct {
+	// MaxResumes is how many times an interrupted transfer is resumed, defaults to 5
+	MaxResumes int
+	// Progress, when set, is called as the body is written with the bytes written
+	// so far and the total size, -1 when unknown
+	Progress func(written, total int64)
+	// SHA256 and MD5 are the expected hex checksums of the body. When both are
+	// empty the checksum announced by the server, if any, is verified instead.
+	SHA256 string
+	MD5    string
+	// Logger, when set, logs every resume
+	Logger resty.Logger
+}
+
+// DownloadResult describes a completed download
+type DownloadResult struct {
+	Size    int64
+	Resumes int
+	ETag    string
+	// SHA256 and MD5 are the hex checksums of the body
+	SHA256 string
+	MD5    string
+}
+
+// ChecksumError is returned when a downloaded body does not match its checksum
+type ChecksumError struct {
+	Algorithm string
+	Expected  string
+	Actual    string
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("httpclient: %s checksum mismatch: expected %s, got %s", e.Algorithm, e.Expected, e.Actual)
+}
+
+// ResourceChangedError is returned when a download streamed to an io.Writer can not
+// be resumed because the resource changed meanwhile
+type ResourceChangedError struct {
+	URL string
+}
+
+func (e *ResourceChangedError) Error() string {
+	return fmt.Sprintf("httpclient: %s changed during the download", e.URL)
+}
+
+// downloadSink receives the body of a download
+type downloadSink interface {
+	io.Writer
+	// restart discards what was written, the resource changed
+	restart() error
+}
+
+type writerSink struct {
+	io.Writer
+	url string
+}
+
+func (w *writerSink) restart() error {
+	return &ResourceChangedError{URL: w.url}
+}
+
+type fileSink struct {
+	*os.File
+}
+
+func (f *fileSink) restart() error {
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	_, err := f.Seek(0, io.SeekStart)
+	return err
+}
+
+// Download streams the body of url to w, sending req once more with a Range header
+// every time the transfer is interrupted. The resume is conditional on the ETag, or
+// the Last-Modified date, of the first response: a changed resource is never
+// stitched to the bytes already written.
+func Download(req *resty.Request, url string, w io.Writer, opts DownloadOptions) (*DownloadResult, error) {
+	return download(req, url, &writerSink{Writer: w, url: url}, opts)
+}
+
+// DownloadFile is Download to the file at path, which is started over if the
+// resource changes. The file is removed if the download fails.
+func DownloadFile(req *resty.Request, url, path string, opts DownloadOptions) (*DownloadResult, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	result, err := download(req, url, &fileSink{File: f}, opts)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	return result, nil
+}
+
+type downloader struct {
+	req  *resty.Request
+	ctx  context.Context
+	url  string
+	sink downloadSink
+	opts DownloadOptions
+
+	written   int64
+	total     int64
+	validator string
+	etag      string
+	sha256    hash.Hash
+	md5       hash.Hash
+	expected  map[string]string
+	writeErr  error
+}
+
+func download(req *resty.Request, url string, sink downloadSink, opts DownloadOptions) (*DownloadResult, error) {
+	if opts.MaxResumes <= 0 {
+		opts.MaxResumes = 5
+	}
+	d := &downloader{
+		req:    req,
+		ctx:    req.Context(),
+		url:    url,
+		sink:   sink,
+		opts:   opts,
+		total:  -1,
+		sha256: sha256.New(),
+		md5:    md5.New(),
+	}
+
+	resumes := 0
+	for {
+		resumable, err := d.fetch()
+		if err == nil {
+			break
+		}
+		if !resumable || resumes >= opts.MaxResumes {
+			return nil, err
+		}
+		if d.written > 0 && d.validator == "" && d.restart() != nil {
+			// nothing proves the resource will not change, and the bytes already
+			// written can not be taken back
+			return nil, err
+		}
+		resumes++
+		if opts.Logger != nil {
+			opts.Logger.Warnf("Download of %s interrupted at %d bytes, resuming: %v", url, d.written, err)
+		}
+	}
+
+	result := &DownloadResult{
+		Size:    d.written,
+		Resumes: resumes,
+		ETag:    d.etag,
+		SHA256:  hex.EncodeToString(d.sha256.Sum(nil)),
+		MD5:     hex.EncodeToString(d.md5.Sum(nil)),
+	}
+	expected := d.expected
+	if opts.SHA256 != "" || opts.MD5 != "" {
+		expected = map[string]string{"sha-256": opts.SHA256, "md5": opts.MD5}
+	}
+	for algorithm, actual := range map[string]string{"sha-256": result.SHA256, "md5": result.MD5} {
+		if want := expected[algorithm]; want != "" && !strings.EqualFold(want, actual) {
+			return nil, &ChecksumError{Algorithm: algorithm, Expected: want, Actual: actual}
+		}
+	}
+	return result, nil
+}
+
+// fetch sends the request for the missing bytes and writes them to the sink, it
+// tells whether a failed transfer can be resumed
+func (d *downloader) fetch() (bool, error) {
+	rewind(d.req, d.ctx)
+	d.req.SetDoNotParseResponse(true)
+	// offsets must count the bytes sent by the server
+	d.req.SetHeader("Accept-Encoding", "identity")
+	d.req.Header.Del("Range")
+	d.req.Header.Del("If-Range")
+	if d.written > 0 {
+		d.req.SetHeader("Range", fmt.Sprintf("bytes=%d-", d.written))
+		d.req.SetHeader("If-Range", d.validator)
+	}
+
+	resp, err := d.req.Execute(resty.MethodGet, d.url)
+	if err != nil {
+		return d.ctx.Err() == nil, err
+	}
+	body := resp.RawBody()
+	defer body.Close()
+
+	status := resp.StatusCode()
+	switch {
+	case status == http.StatusPartialContent && d.written > 0:
+		if start := contentRangeStart(resp.Header().Get("Content-Range")); start != d.written {
+			err = fmt.Errorf("httpclient: download of %s resumed at byte %d instead of %d", d.url, start, d.written)
+			endSpan(resp.Request, status, err)
+			return false, err
+		}
+	case status == http.StatusOK:
+		if d.written > 0 {
+			// If-Range did not match, the whole new resource follows
+			if err := d.restart(); err != nil {
+				endSpan(resp.Request, status, err)
+				return false, err
+			}
+		}
+		d.start(resp)
+	default:
+		err = AsError(resp, nil)
+		if err == nil {
+			err = fmt.Errorf("httpclient: download of %s got status %d", d.url, status)
+		}
+		endSpan(resp.Request, status, nil)
+		return false, err
+	}
+
+	_, err = io.Copy(d, body)
+	if err == nil && d.total >= 0 && d.written < d.total {
+		err = io.ErrUnexpectedEOF
+	}
+	endSpan(resp.Request, status, err)
+	if d.writeErr != nil {
+		return false, d.writeErr
+	}
+	if err != nil {
+		return d.ctx.Err() == nil, err
+	}
+	return false, nil
+}
+
+// start takes the size, the validator and the checksums of a complete response
+func (d *downloader) start(resp *resty.Response) {
+	d.total = resp.RawResponse.ContentLength
+	d.etag = resp.Header().Get("ETag")
+	d.validator = d.etag
+	if d.validator == "" || strings.HasPrefix(d.validator, "W/") {
+		// weak tags can not validate a range
+		d.validator = resp.Header().Get("Last-Modified")
+	}
+	d.expected = announcedChecksums(resp.Header())
+}
+
+func (d *downloader) restart() error {
+	if err := d.sink.restart(); err != nil {
+		return err
+	}
+	d.written = 0
+	d.total = -1
+	d.sha256.Reset()
+	d.md5.Reset()
+	return nil
+}
+
+func (d *downloader) Write(p []byte) (int, error) {
+	n, err := d.sink.Write(p)
+	d.sha256.Write(p[:n])
+	d.md5.Write(p[:n])
+	d.written += int64(n)
+	if err != nil {
+		d.writeErr = err
+		return n, err
+	}
+	if d.opts.Progress != nil {
+		d.opts.Progress(d.written, d.total)
+	}
+	return n, nil
+}
+
+// contentRangeStart returns the first byte of a "bytes first-last/total" range
+func contentRangeStart(value string) int64 {
+	value = strings.TrimPrefix(value, "bytes ")
+	dash := strings.IndexByte(value, '-')
+	if dash < 0 {
+		return -1
+	}
+	start, err := strconv.ParseInt(value[:dash], 10, 64)
+	if err != nil {
+		return -1
+	}
+	return start
+}
+
+// announcedChecksums returns the hex checksums sent in the Digest (RFC 3230),
+// Content-MD5 and X-Checksum-* headers, keyed by algorithm
+func announcedChecksums(header http.Header) map[string]string {
+	ret := map[string]string{}
+	fromBase64 := func(algorithm, value string) {
+		if sum, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value)); err == nil {
+			ret[algorithm] = hex.EncodeToString(sum)
+		}
+	}
+
+	for _, digest := range strings.Split(header.Get("Digest"), ",") {
+		kv := strings.SplitN(strings.TrimSpace(digest), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch algorithm := strings.ToLower(kv[0]); algorithm {
+		case "sha-256", "md5":
+			fromBase64(algorithm, kv[1])
+		}
+	}
+	if value := header.Get("Content-MD5"); value != "" {
+		fromBase64("md5", value)
+	}
+	if value := header.Get("X-Checksum-Sha256"); value != "" {
+		ret["sha-256"] = strings.ToLower(value)
+	}
+	if value := header.Get("X-Checksum-Md5"); value != "" {
+		ret["md5"] = strings.ToLower(value)
+	}
+	return ret
+}
@@ -0,0 +1,187 @@
+package httpclient_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/SpazioDati/go-utils/httpclient"
+	"github.com/stretchr/testify/assert"
+)
+
+// blobServer serves content with Range support, dropping the connection of the
+// first drops responses after 30000 bytes
+type blobServer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	content []byte
+	etag    string
+	drops   int
+	// change replaces the content after the first drop
+	change []byte
+	ranges []string
+}
+
+type droppingWriter struct {
+	http.ResponseWriter
+	left int
+}
+
+func (w *droppingWriter) Write(p []byte) (int, error) {
+	if len(p) < w.left {
+		w.left -= len(p)
+		return w.ResponseWriter.Write(p)
+	}
+	w.ResponseWriter.Write(p[:w.left])
+	w.ResponseWriter.(http.Flusher).Flush()
+	panic(http.ErrAbortHandler)
+}
+
+func newBlobServer(content []byte, drops int) *blobServer {
+	b := &blobServer{content: content, etag: `"v1"`, drops: drops}
+	b.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b.mu.Lock()
+		if r.Header.Get("Range") != "" {
+			b.ranges = append(b.ranges, r.Header.Get("Range")+" "+r.Header.Get("If-Range"))
+		}
+		content, etag := b.content, b.etag
+		drop := b.drops > 0
+		if drop {
+			b.drops--
+			if b.change != nil {
+				b.content, b.etag = b.change, `"v2"`
+			}
+		}
+		b.mu.Unlock()
+
+		sum := sha256.Sum256(content)
+		w.Header().Set("ETag", etag)
+		w.Header().Set("X-Checksum-Sha256", hex.EncodeToString(sum[:]))
+		if drop {
+			w = &droppingWriter{ResponseWriter: w, left: 30000}
+		}
+		http.ServeContent(w, r, "blob", time.Time{}, bytes.NewReader(content))
+	}))
+	return b
+}
+
+func blob(size int, seed int64) []byte {
+	content := make([]byte, size)
+	rand.New(rand.NewSource(seed)).Read(content)
+	return content
+}
+
+func TestDownloadResumes(t *testing.T) {
+	assert := assert.New(t)
+	content := blob(100000, 1)
+	ts := newBlobServer(content, 2)
+	defer ts.Close()
+
+	client := New(Options{
+		HTTPClient: &http.Client{},
+		Logger:     &logMock{},
+	})
+	logger := &logMock{}
+	var written, total int64
+	out := &bytes.Buffer{}
+	result, err := Download(client.R(), ts.URL, out, DownloadOptions{
+		Logger: logger,
+		Progress: func(w, t int64) {
+			written, total = w, t
+		},
+	})
+	assert.Nil(err)
+	assert.Equal(content, out.Bytes())
+	assert.Equal(2, result.Resumes)
+	assert.Equal(int64(len(content)), result.Size)
+	assert.Equal(`"v1"`, result.ETag)
+	assert.Equal([]string{`bytes=30000- "v1"`, `bytes=60000- "v1"`}, ts.ranges)
+	assert.Equal(int64(len(content)), written)
+	assert.Equal(int64(len(content)), total)
+	assert.Equal("Download of %s interrupted at %d bytes, resuming: %v", logger.Format)
+
+	ts.mu.Lock()
+	ts.drops = 10
+	ts.mu.Unlock()
+	_, err = Download(client.R(), ts.URL, ioutil.Discard, DownloadOptions{MaxResumes: 2})
+	assert.True(errors.Is(err, io.ErrUnexpectedEOF), "%v", err)
+}
+
+func TestDownloadChanged(t *testing.T) {
+	assert := assert.New(t)
+	content, changed := blob(100000, 1), blob(80000, 2)
+	client := New(Options{
+		HTTPClient:     &http.Client{},
+		Logger:         &logMock{},
+		DisableTracing: true,
+	})
+
+	ts := newBlobServer(content, 1)
+	ts.change = changed
+	defer ts.Close()
+	_, err := Download(client.R(), ts.URL, &bytes.Buffer{}, DownloadOptions{})
+	var changedErr *ResourceChangedError
+	assert.True(errors.As(err, &changedErr))
+
+	ts.mu.Lock()
+	ts.content, ts.etag, ts.drops = content, `"v1"`, 1
+	ts.mu.Unlock()
+	path := filepath.Join(t.TempDir(), "blob")
+	result, err := DownloadFile(client.R(), ts.URL, path, DownloadOptions{})
+	assert.Nil(err)
+	assert.Equal(`"v2"`, result.ETag)
+	saved, _ := ioutil.ReadFile(path)
+	assert.Equal(changed, saved, "the file is started over")
+}
+
+func TestDownloadChecksum(t *testing.T) {
+	assert := assert.New(t)
+	content := blob(1000, 1)
+	ts := newBlobServer(content, 0)
+	defer ts.Close()
+	client := New(Options{
+		HTTPClient:     &http.Client{},
+		Logger:         &logMock{},
+		DisableTracing: true,
+	})
+
+	result, err := Download(client.R(), ts.URL, ioutil.Discard, DownloadOptions{})
+	assert.Nil(err)
+	sum := sha256.Sum256(content)
+	assert.Equal(hex.EncodeToString(sum[:]), result.SHA256)
+
+	_, err = Download(client.R(), ts.URL, ioutil.Discard, DownloadOptions{MD5: result.MD5})
+	assert.Nil(err)
+
+	path := filepath.Join(t.TempDir(), "blob")
+	_, err = DownloadFile(client.R(), ts.URL, path, DownloadOptions{SHA256: "00"})
+	var checksumErr *ChecksumError
+	assert.True(errors.As(err, &checksumErr))
+	assert.Equal("sha-256", checksumErr.Algorithm)
+	_, err = os.Stat(path)
+	assert.True(os.IsNotExist(err), "a corrupted file is removed")
+
+	announced := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Digest", "sha-256=X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=")
+		w.Write([]byte("corrupted"))
+	}))
+	defer announced.Close()
+	_, err = Download(client.R(), announced.URL, ioutil.Discard, DownloadOptions{})
+	assert.True(errors.As(err, &checksumErr), "the Digest header is verified")
+
+	missing := httptest.NewServer(http.NotFoundHandler())
+	defer missing.Close()
+	_, err = Download(client.R(), missing.URL, ioutil.Discard, DownloadOptions{})
+	assert.True(errors.Is(err, ErrClientError))
+}
@@ -0,0 +1,222 @@
+package httpclient
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// Kinds of RequestError, to be matched with errors.Is
+var (
+	ErrTimeout           = errors.New("httpclient: timeout")
+	ErrDNS               = errors.New("httpclient: dns failure")
+	ErrConnectionRefused = errors.New("httpclient: connection refused")
+	ErrConnectionReset   = errors.New("httpclient: connection reset")
+	ErrTLS               = errors.New("httpclient: tls failure")
+	ErrCircuitOpen       = errors.New("httpclient: circuit open")
+	ErrRateLimited       = errors.New("httpclient: rate limited")
+	ErrClientError       = errors.New("httpclient: client error")
+	ErrServerError       = errors.New("httpclient: server error")
+	ErrTransport         = errors.New("httpclient: transport failure")
+)
+
+// errorKinds maps each kind to its label for spans and metrics
+var errorKinds = map[error]string{
+	ErrTimeout:           "timeout",
+	ErrDNS:               "dns",
+	ErrConnectionRefused: "connection_refused",
+	ErrConnectionReset:   "connection_reset",
+	ErrTLS:               "tls",
+	ErrCircuitOpen:       "circuit_open",
+	ErrRateLimited:       "rate_limited",
+	ErrClientError:       "client_error",
+	ErrServerError:       "server_error",
+	ErrTransport:         "transport",
+}
+
+// MaxErrorBody is the number of bytes of the response body kept by a RequestError
+var MaxErrorBody = 512
+
+// RequestError is a failed request. errors.Is matches its Kind, errors.As reaches
+// the underlying error, e.g. a *CircuitOpenError.
+type RequestError struct {
+	Kind     error
+	Method   string
+	Host     string
+	Status   int
+	Attempts int
+	// Body is the beginning of the response body, if any
+	Body string
+	Err  error
+}
+
+func (e *RequestError) Error() string {
+	msg := fmt.Sprintf("%s: %s %s", e.Kind, e.Method, e.Host)
+	if e.Status > 0 {
+		msg += fmt.Sprintf(" returned %d", e.Status)
+	}
+	if e.Attempts > 1 {
+		msg += fmt.Sprintf(" after %d attempts", e.Attempts)
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	if e.Body != "" {
+		msg += ": " + e.Body
+	}
+	return msg
+}
+
+func (e *RequestError) Is(target error) bool {
+	return target == e.Kind
+}
+
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
+
+// errorKind returns the kind of a failed attempt, nil when it succeeded
+func errorKind(status int, err error) error {
+	if err == nil {
+		switch {
+		case status >= http.StatusInternalServerError:
+			return ErrServerError
+		case status >= http.StatusBadRequest:
+			return ErrClientError
+		}
+		return nil
+	}
+
+	var open *CircuitOpenError
+	var limited *RateLimitedError
+	switch {
+	case errors.As(err, &open):
+		return ErrCircuitOpen
+	case errors.As(err, &limited):
+		return ErrRateLimited
+	}
+
+	switch ClassifyError(err) {
+	case ClassTimeout:
+		return ErrTimeout
+	case ClassDNS:
+		return ErrDNS
+	case ClassConnectionRefused:
+		return ErrConnectionRefused
+	case ClassConnectionReset:
+		return ErrConnectionReset
+	case ClassTLS:
+		return ErrTLS
+	}
+	return ErrTransport
+}
+
+// ErrorKind returns a label for the failure of a request, e.g. "timeout" or
+// "server_error", to be used in spans and metrics. It is empty for a success.
+func ErrorKind(status int, err error) string {
+	var reqErr *RequestError
+	if errors.As(err, &reqErr) {
+		return errorKinds[reqErr.Kind]
+	}
+	return errorKinds[errorKind(status, err)]
+}
+
+// requestError wraps err, the failure of a request to host, into a *RequestError
+// unless it already holds one
+func requestError(method, host string, err error) error {
+	var reqErr *RequestError
+	if err == nil || errors.As(err, &reqErr) {
+		return err
+	}
+	return &RequestError{Kind: errorKind(0, err), Method: method, Host: host, Err: err}
+}
+
+// ErrorTransport defines a http.RoundTripper turning the errors of T, and those of
+// reading the response body, into *RequestError. The clients built by New use it as
+// their outermost transport, so their errors match the kinds with errors.Is. The
+// http.Client replaces the error of a request exceeding its Timeout, AsError and
+// ErrorKind still classify it as ErrTimeout.
+type ErrorTransport struct {
+	T http.RoundTripper
+}
+
+func (et *ErrorTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := et.T.RoundTrip(req)
+	if err != nil {
+		return nil, requestError(req.Method, req.URL.Host, err)
+	}
+	if resp.Body != nil && resp.Body != http.NoBody {
+		resp.Body = &errorBody{ReadCloser: resp.Body, method: req.Method, host: req.URL.Host}
+	}
+	return resp, nil
+}
+
+type errorBody struct {
+	io.ReadCloser
+	method string
+	host   string
+}
+
+func (b *errorBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF {
+		err = requestError(b.method, b.host, err)
+	}
+	return n, err
+}
+
+// AsError turns the outcome of a request into a *RequestError, it returns nil for
+// responses below 400 and errors. The errors of the clients built by New already
+// are *RequestError, AsError adds the number of attempts to them.
+//
+//	resp, err := client.R().Get(url)
+//	if err := httpclient.AsError(resp, err); errors.Is(err, httpclient.ErrServerError) {
+func AsError(resp *resty.Response, err error) error {
+	status := 0
+	if resp != nil && resp.RawResponse != nil {
+		status = resp.StatusCode()
+	}
+	var reqErr *RequestError
+	if errors.As(err, &reqErr) {
+		wrapped := *reqErr
+		if resp != nil && resp.Request != nil {
+			wrapped.Attempts = resp.Request.Attempt
+		}
+		return &wrapped
+	}
+	kind := errorKind(status, err)
+	if kind == nil {
+		return nil
+	}
+
+	reqErr = &RequestError{Kind: kind, Status: status, Err: err}
+	if resp != nil && resp.Request != nil {
+		r := resp.Request
+		reqErr.Method = r.Method
+		reqErr.Attempts = r.Attempt
+		if u := requestURL(nil, r); u != nil {
+			reqErr.Host = u.Host
+		}
+	}
+	var open *CircuitOpenError
+	var limited *RateLimitedError
+	switch {
+	case reqErr.Host != "":
+	case errors.As(err, &open):
+		reqErr.Host = open.Host
+	case errors.As(err, &limited):
+		reqErr.Host = limited.Host
+	}
+	if status > 0 {
+		body := strings.TrimSpace(string(resp.Body()))
+		if len(body) > MaxErrorBody {
+			body = body[:MaxErrorBody] + "..."
+		}
+		reqErr.Body = body
+	}
+	return reqErr
+}
@@ -0,0 +1,110 @@
+package httpclient_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/SpazioDati/go-utils/httpclient"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAsError(t *testing.T) {
+	assert := assert.New(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/missing":
+			w.WriteHeader(http.StatusNotFound)
+		case "/broken":
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(strings.Repeat("x", 1000)))
+		case "/slow":
+			time.Sleep(50 * time.Millisecond)
+		}
+	}))
+	defer ts.Close()
+	host := strings.TrimPrefix(ts.URL, "http://")
+
+	client := New(Options{
+		HTTPClient:     &http.Client{},
+		Logger:         &logMock{},
+		Retries:        2,
+		DisableTracing: true,
+		Backoff:        &BackoffPolicy{BaseDelay: time.Millisecond},
+	})
+
+	resp, err := client.R().Get(ts.URL)
+	assert.Nil(AsError(resp, err))
+
+	resp, err = client.R().Get(ts.URL + "/missing")
+	err = AsError(resp, err)
+	assert.True(errors.Is(err, ErrClientError))
+	assert.Equal("client_error", ErrorKind(0, err))
+
+	resp, err = client.R().Get(ts.URL + "/broken")
+	err = AsError(resp, err)
+	assert.True(errors.Is(err, ErrServerError))
+	assert.False(errors.Is(err, ErrClientError))
+	var reqErr *RequestError
+	assert.True(errors.As(err, &reqErr))
+	assert.Equal(host, reqErr.Host)
+	assert.Equal(http.StatusServiceUnavailable, reqErr.Status)
+	assert.Equal(3, reqErr.Attempts)
+	assert.Len(reqErr.Body, MaxErrorBody+3)
+
+	resp, err = client.R().Post(ts.URL + "/broken")
+	assert.Equal(1, AsError(resp, err).(*RequestError).Attempts, "POST is not retried")
+
+	client.SetTimeout(10 * time.Millisecond).SetRetryCount(0)
+	resp, err = client.R().Get(ts.URL + "/slow")
+	assert.True(errors.Is(AsError(resp, err), ErrTimeout))
+}
+
+func TestAsErrorTransport(t *testing.T) {
+	assert := assert.New(t)
+
+	closed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	closed.Close()
+	tlsServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer tlsServer.Close()
+
+	client := New(Options{
+		HTTPClient:     &http.Client{},
+		Logger:         &logMock{},
+		DisableTracing: true,
+		Breaker:        NewBreaker(nil, BreakerOptions{FailureThreshold: 1, OpenTimeout: time.Minute}),
+	})
+
+	resp, err := client.R().Get(closed.URL)
+	assert.True(errors.Is(err, ErrConnectionRefused), "the errors of the client are classified")
+	err = AsError(resp, err)
+	assert.True(errors.Is(err, ErrConnectionRefused))
+	assert.Equal(1, err.(*RequestError).Attempts)
+	assert.Equal("connection_refused", ErrorKind(0, err))
+
+	resp, err = client.R().Get(closed.URL)
+	err = AsError(resp, err)
+	assert.True(errors.Is(err, ErrCircuitOpen))
+	var open *CircuitOpenError
+	assert.True(errors.As(err, &open), "the cause is still reachable")
+	assert.Equal(strings.TrimPrefix(closed.URL, "http://"), err.(*RequestError).Host)
+
+	resp, err = client.R().Get(tlsServer.URL)
+	assert.True(errors.Is(AsError(resp, err), ErrTLS))
+
+	client = New(Options{
+		HTTPClient:     &http.Client{},
+		Logger:         &logMock{},
+		DisableTracing: true,
+		RateLimiter:    NewRateLimiter(RateLimiterOptions{Default: RateLimit{RPS: 1, Burst: 1}}),
+	})
+	client.R().Get(closed.URL)
+	resp, err = client.R().Get(closed.URL)
+	assert.True(errors.Is(err, ErrRateLimited))
+	err = AsError(resp, err)
+	assert.True(errors.Is(err, ErrRateLimited))
+	assert.Equal(strings.TrimPrefix(closed.URL, "http://"), err.(*RequestError).Host)
+}
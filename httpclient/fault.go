@@ -0,0 +1,256 @@
+package httpclient
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-resty/resty/v2"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// HDRInjectedFault marks the synthetic responses of a FaultTransport
+const HDRInjectedFault = "X-Injected-Fault"
+
+// Fault describes the failures injected in the requests matching Scope. Percentages
+// go from 0 to 100, zero disables the corresponding failure.
+type Fault struct {
+	Scope TraceScope
+	// Latency is added before sending the request
+	Latency        time.Duration
+	LatencyPercent float64
+	// Status is returned instead of sending the request
+	Status        int
+	StatusPercent float64
+	// Error is returned instead of sending the request, one of ClassConnectionRefused
+	// (default), ClassConnectionReset, ClassTimeout and ClassDNS
+	Error        ErrorClass
+	ErrorPercent float64
+}
+
+// FaultError is the error returned for an injected connection failure
+type FaultError struct {
+	Host string
+	Err  error
+}
+
+func (e *FaultError) Error() string {
+	return fmt.Sprintf("httpclient: injected fault for host %s: %v", e.Host, e.Err)
+}
+
+func (e *FaultError) Unwrap() error {
+	return e.Err
+}
+
+func faultError(class ErrorClass, host string) error {
+	var err error
+	switch class {
+	case ClassConnectionReset:
+		err = syscall.ECONNRESET
+	case ClassTimeout:
+		err = context.DeadlineExceeded
+	case ClassDNS:
+		err = &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	default:
+		err = syscall.ECONNREFUSED
+	}
+	return &FaultError{Host: host, Err: err}
+}
+
+// FaultSession is a time-boxed Fault
+type FaultSession struct {
+	ID      uint64
+	Fault   Fault
+	Expires time.Time
+}
+
+// FaultInjector holds the active faults, each session expires on its own as those of
+// a TraceRegistry
+type FaultInjector struct {
+	sessions scopedSessions
+}
+
+// DefaultFaultInjector is the injector used by FaultInjectorMW and the clients built
+// by New without Options.FaultInjector
+var DefaultFaultInjector = NewFaultInjector()
+
+func NewFaultInjector() *FaultInjector {
+	return &FaultInjector{}
+}
+
+// Enable injects fault for timeout
+func (f *FaultInjector) Enable(fault Fault, timeout time.Duration) FaultSession {
+	session := f.sessions.add(fault.Scope, fault, timeout)
+	return FaultSession{ID: session.id, Fault: fault, Expires: session.expires}
+}
+
+// Disable stops the session with the given id
+func (f *FaultInjector) Disable(id uint64) {
+	f.sessions.remove(id)
+}
+
+// DisableAll stops every session
+func (f *FaultInjector) DisableAll() {
+	f.sessions.clear()
+}
+
+// Sessions returns the active sessions
+func (f *FaultInjector) Sessions() []FaultSession {
+	active := f.sessions.active()
+	ret := make([]FaultSession, 0, len(active))
+	for _, s := range active {
+		ret = append(ret, FaultSession{ID: s.id, Fault: s.value.(Fault), Expires: s.expires})
+	}
+	return ret
+}
+
+// faults returns the active faults matching req
+func (f *FaultInjector) faults(req *http.Request) []Fault {
+	ret := []Fault{}
+	f.sessions.each(req.Context(), req.URL, func(s scopedSession) bool {
+		ret = append(ret, s.value.(Fault))
+		return true
+	})
+	return ret
+}
+
+func roll(percent float64) bool {
+	return percent > 0 && rand.Float64()*100 < percent
+}
+
+// FaultTransport defines a http.RoundTripper injecting the faults of a FaultInjector.
+// Every injected fault is logged and set on the attempt span, synthetic responses
+// carry the HDRInjectedFault header.
+type FaultTransport struct {
+	T        http.RoundTripper
+	Injector *FaultInjector
+	Logger   resty.Logger
+}
+
+func (ft *FaultTransport) inject(req *http.Request, kind string, attr attribute.KeyValue) {
+	if ft.Logger != nil {
+		ft.Logger.Warnf("Injected fault on %s %s: %s", req.Method, req.URL.String(), kind)
+	}
+	attemptSpan(req.Context()).SetAttributes(attribute.Bool("http.fault_injected", true), attr)
+}
+
+func (ft *FaultTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for _, fault := range ft.Injector.faults(req) {
+		if roll(fault.LatencyPercent) {
+			ft.inject(req, fmt.Sprintf("latency %s", fault.Latency), attribute.Float64("http.fault.latency_ms", milliseconds(fault.Latency)))
+			timer := time.NewTimer(fault.Latency)
+			select {
+			case <-timer.C:
+			case <-req.Context().Done():
+				timer.Stop()
+				return nil, req.Context().Err()
+			}
+		}
+		if roll(fault.ErrorPercent) {
+			err := faultError(fault.Error, req.URL.Host)
+			ft.inject(req, err.Error(), attribute.String("http.fault.error", ClassifyError(err).String()))
+			return nil, err
+		}
+		if roll(fault.StatusPercent) {
+			ft.inject(req, fmt.Sprintf("status %d", fault.Status), attribute.Int("http.fault.status", fault.Status))
+			body := fmt.Sprintf("injected fault: status %d", fault.Status)
+			return &http.Response{
+				Status:        fmt.Sprintf("%d %s", fault.Status, http.StatusText(fault.Status)),
+				StatusCode:    fault.Status,
+				Proto:         "HTTP/1.1",
+				ProtoMajor:    1,
+				ProtoMinor:    1,
+				Header:        http.Header{HDRInjectedFault: []string{"status"}, "Content-Type": []string{"text/plain"}},
+				Body:          ioutil.NopCloser(strings.NewReader(body)),
+				ContentLength: int64(len(body)),
+				Request:       req,
+			}, nil
+		}
+	}
+	return ft.T.RoundTrip(req)
+}
+
+// FaultInjectorMW injects a fault for "to" minutes (default 15) with
+// DefaultFaultInjector. The fault is described by the "latency" (ms), "status" and
+// "error" (refused, reset, timeout or dns) query parameters, each applied to the
+// percentage of requests in "latency_pct", "status_pct" and "error_pct" (default
+// 100). It can be scoped like TraceEnablerMW.
+func FaultInjectorMW(logger resty.Logger) gin.HandlerFunc {
+	errorClasses := map[string]ErrorClass{
+		"refused": ClassConnectionRefused,
+		"reset":   ClassConnectionReset,
+		"timeout": ClassTimeout,
+		"dns":     ClassDNS,
+	}
+	percent := func(c *gin.Context, name string) (float64, error) {
+		value, ok := c.GetQuery(name)
+		if !ok {
+			return 100, nil
+		}
+		pct, err := strconv.ParseFloat(value, 64)
+		if err != nil || pct < 0 || pct > 100 {
+			return 0, fmt.Errorf("invalid %s %q", name, value)
+		}
+		return pct, nil
+	}
+
+	return func(c *gin.Context) {
+		to := sessionTimeout(c)
+		fault := Fault{Scope: sessionScope(c)}
+
+		var err error
+		if latency, ok := c.GetQuery("latency"); ok {
+			ms, convErr := strconv.Atoi(latency)
+			if convErr != nil || ms < 0 {
+				c.String(http.StatusBadRequest, "invalid latency %q", latency)
+				return
+			}
+			fault.Latency = time.Duration(ms) * time.Millisecond
+			if fault.LatencyPercent, err = percent(c, "latency_pct"); err != nil {
+				c.String(http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+		if status, ok := c.GetQuery("status"); ok {
+			code, convErr := strconv.Atoi(status)
+			if convErr != nil || code < 100 || code > 599 {
+				c.String(http.StatusBadRequest, "invalid status %q", status)
+				return
+			}
+			fault.Status = code
+			if fault.StatusPercent, err = percent(c, "status_pct"); err != nil {
+				c.String(http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+		if name, ok := c.GetQuery("error"); ok {
+			class, known := errorClasses[name]
+			if !known {
+				c.String(http.StatusBadRequest, "invalid error %q", name)
+				return
+			}
+			fault.Error = class
+			if fault.ErrorPercent, err = percent(c, "error_pct"); err != nil {
+				c.String(http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+
+		if fault.LatencyPercent == 0 && fault.StatusPercent == 0 && fault.ErrorPercent == 0 {
+			c.String(http.StatusBadRequest, "no fault to inject, set latency, status or error")
+			return
+		}
+
+		logger.Warnf("Enabling fault injection for %d minutes on %s", int(to.Minutes()), fault.Scope)
+		DefaultFaultInjector.Enable(fault, to)
+		c.String(http.StatusOK, "OK")
+	}
+}
@@ -0,0 +1,126 @@
+package httpclient_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/SpazioDati/go-utils/httpclient"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFaultTransport(t *testing.T) {
+	assert := assert.New(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	injector := NewFaultInjector()
+	logger := &logMock{}
+	client := New(Options{
+		HTTPClient:     &http.Client{},
+		Logger:         logger,
+		DisableTracing: true,
+		FaultInjector:  injector,
+	})
+
+	injector.Enable(Fault{
+		Scope:         TraceScope{PathPrefix: "/status"},
+		Status:        http.StatusServiceUnavailable,
+		StatusPercent: 100,
+	}, time.Minute)
+	injector.Enable(Fault{
+		Scope:        TraceScope{PathPrefix: "/error"},
+		Latency:      20 * time.Millisecond,
+		ErrorPercent: 100,
+	}, time.Minute)
+	injector.Enable(Fault{
+		Scope:          TraceScope{PathPrefix: "/slow"},
+		Latency:        20 * time.Millisecond,
+		LatencyPercent: 100,
+	}, time.Minute)
+
+	resp, err := client.R().Get(ts.URL + "/status")
+	assert.Nil(err)
+	assert.Equal(http.StatusServiceUnavailable, resp.StatusCode())
+	assert.Equal("status", resp.Header().Get(HDRInjectedFault))
+	assert.Equal("Injected fault on %s %s: %s", logger.Format)
+
+	start := time.Now()
+	_, err = client.R().Get(ts.URL + "/error")
+	var fault *FaultError
+	assert.True(errors.As(err, &fault))
+	assert.Equal(ClassConnectionRefused, ClassifyError(err))
+	assert.True(time.Since(start) < 20*time.Millisecond, "no latency without LatencyPercent")
+
+	start = time.Now()
+	resp, err = client.R().Get(ts.URL + "/slow")
+	assert.Nil(err)
+	assert.Equal(http.StatusOK, resp.StatusCode())
+	assert.True(time.Since(start) >= 20*time.Millisecond)
+
+	resp, err = client.R().Get(ts.URL + "/ok")
+	assert.Nil(err)
+	assert.Empty(resp.Header().Get(HDRInjectedFault))
+
+	expired := injector.Enable(Fault{Status: http.StatusBadGateway, StatusPercent: 100}, 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	resp, err = client.R().Get(ts.URL + "/ok")
+	assert.Nil(err)
+	assert.Equal(http.StatusOK, resp.StatusCode(), "an expired fault is not injected")
+	injector.Disable(expired.ID)
+	assert.Len(injector.Sessions(), 3)
+
+	injector.DisableAll()
+	resp, err = client.R().Get(ts.URL + "/status")
+	assert.Nil(err)
+	assert.Equal(http.StatusOK, resp.StatusCode())
+}
+
+func TestFaultInjectorMW(t *testing.T) {
+	assert := assert.New(t)
+	defer DefaultFaultInjector.DisableAll()
+
+	r := gin.New()
+	r.GET("/", FaultInjectorMW(&logMock{}))
+
+	for _, query := range []string{"status=700", "error=boom", "latency=-1", "status=503&status_pct=200", "", "to=5&host=foo.bar"} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/?"+query, nil)
+		r.ServeHTTP(w, req)
+		assert.Equal(http.StatusBadRequest, w.Code, query)
+	}
+	assert.Empty(DefaultFaultInjector.Sessions())
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/?to=5&host=foo.bar&status=503&status_pct=50&error=reset", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(http.StatusOK, w.Code)
+
+	sessions := DefaultFaultInjector.Sessions()
+	assert.Len(sessions, 1)
+	assert.Equal(Fault{
+		Scope:         TraceScope{Host: "foo.bar"},
+		Status:        http.StatusServiceUnavailable,
+		StatusPercent: 50,
+		Error:         ClassConnectionReset,
+		ErrorPercent:  100,
+	}, sessions[0].Fault)
+	assert.WithinDuration(time.Now().Add(5*time.Minute), sessions[0].Expires, time.Second)
+}
+
+func TestFaultTransportSharedClient(t *testing.T) {
+	assert := assert.New(t)
+	transport := &http.Transport{}
+	shared := &http.Client{Transport: transport}
+
+	for i := 0; i < 3; i++ {
+		client := New(Options{HTTPClient: shared, Logger: &logMock{}, DisableTracing: true})
+		assert.NotEqual(transport, client.GetClient().Transport)
+	}
+	assert.Same(transport, shared.Transport, "the client of the options is not modified")
+}
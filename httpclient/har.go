@@ -0,0 +1,394 @@
+package httpclient
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// HAR is an HTTP Archive 1.2, only the fields used by HARRecorder and HARReplayer
+// are modelled
+type HAR struct {
+	Log HARLog `json:"log"`
+}
+
+type HARLog struct {
+	Version string     `json:"version"`
+	Creator HARCreator `json:"creator"`
+	Entries []HAREntry `json:"entries"`
+}
+
+type HARCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type HAREntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         HARRequest  `json:"request"`
+	Response        HARResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         HARTimings  `json:"timings"`
+}
+
+type HARNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type HARRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARNameValue `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	QueryString []HARNameValue `json:"queryString"`
+	PostData    *HARPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type HARPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type HARResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARNameValue `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	Content     HARContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+// HARContent holds the response body, base64 encoded when it is not valid UTF-8
+type HARContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// HARTimings only splits the time to the response headers from the time to read the body
+type HARTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+func harHeaders(h http.Header) []HARNameValue {
+	ret := []HARNameValue{}
+	for name, values := range h {
+		for _, v := range values {
+			ret = append(ret, HARNameValue{Name: name, Value: v})
+		}
+	}
+	sort.SliceStable(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+	return ret
+}
+
+func harQuery(u *url.URL) []HARNameValue {
+	ret := []HARNameValue{}
+	for name, values := range u.Query() {
+		for _, v := range values {
+			ret = append(ret, HARNameValue{Name: name, Value: v})
+		}
+	}
+	sort.SliceStable(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+	return ret
+}
+
+func harCookies(cookies []*http.Cookie, redact bool) []HARNameValue {
+	ret := []HARNameValue{}
+	for _, c := range cookies {
+		value := c.Value
+		if redact {
+			value = redacted
+		}
+		ret = append(ret, HARNameValue{Name: c.Name, Value: value})
+	}
+	return ret
+}
+
+// readBody reads and restores a request or response body
+func readBody(body *io.ReadCloser) ([]byte, error) {
+	if *body == nil || *body == http.NoBody {
+		return nil, nil
+	}
+	data, err := ioutil.ReadAll(*body)
+	(*body).Close()
+	if err != nil {
+		return nil, err
+	}
+	*body = ioutil.NopCloser(bytes.NewReader(data))
+	return data, nil
+}
+
+// LoadHAR reads a HAR file
+func LoadHAR(path string) (*HAR, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	har := &HAR{}
+	if err := json.Unmarshal(data, har); err != nil {
+		return nil, fmt.Errorf("httpclient: invalid HAR file %s: %w", path, err)
+	}
+	return har, nil
+}
+
+// HARRecorder defines a http.RoundTripper saving every exchange to a HAR file. The
+// file is rewritten after each exchange, so it is complete even if the test fails.
+type HARRecorder struct {
+	T    http.RoundTripper
+	Path string
+	// Redactor redacts the saved headers, cookies and query parameters as in its
+	// logs, defaults to DefaultBodyLogger. The bodies are saved as they are.
+	Redactor *BodyLogger
+	mu       sync.Mutex
+	har      HAR
+}
+
+// NewHARRecorder records the exchanges of t, or of http.DefaultTransport if nil, to path
+func NewHARRecorder(path string, t http.RoundTripper) *HARRecorder {
+	if t == nil {
+		t = http.DefaultTransport
+	}
+	return &HARRecorder{
+		T:        t,
+		Path:     path,
+		Redactor: DefaultBodyLogger,
+		har: HAR{Log: HARLog{
+			Version: "1.2",
+			Creator: HARCreator{Name: "go-utils/httpclient", Version: "1.0"},
+			Entries: []HAREntry{},
+		}},
+	}
+}
+
+func (hr *HARRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	reqBody, err := readBody(&req.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	resp, err := hr.T.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	wait := time.Since(start)
+	respBody, err := readBody(&resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	total := time.Since(start)
+
+	redactor := hr.Redactor
+	if redactor == nil {
+		redactor = DefaultBodyLogger
+	}
+	recordedURL := redactor.redactURL(req.URL.String())
+	query := []HARNameValue{}
+	if parsed, err := url.Parse(recordedURL); err == nil {
+		query = harQuery(parsed)
+	}
+
+	entry := HAREntry{
+		StartedDateTime: start,
+		Time:            milliseconds(total),
+		Request: HARRequest{
+			Method:      req.Method,
+			URL:         recordedURL,
+			HTTPVersion: req.Proto,
+			Cookies:     harCookies(req.Cookies(), redactor.headers["Cookie"]),
+			Headers:     harHeaders(redactor.redactHeaders(req.Header)),
+			QueryString: query,
+			HeadersSize: -1,
+			BodySize:    len(reqBody),
+		},
+		Response: HARResponse{
+			Status:      resp.StatusCode,
+			StatusText:  http.StatusText(resp.StatusCode),
+			HTTPVersion: resp.Proto,
+			Cookies:     harCookies(resp.Cookies(), redactor.headers["Set-Cookie"]),
+			Headers:     harHeaders(redactor.redactHeaders(resp.Header)),
+			Content: HARContent{
+				Size:     len(respBody),
+				MimeType: resp.Header.Get("Content-Type"),
+			},
+			RedirectURL: resp.Header.Get("Location"),
+			HeadersSize: -1,
+			BodySize:    len(respBody),
+		},
+		Timings: HARTimings{Wait: milliseconds(wait), Receive: milliseconds(total - wait)},
+	}
+	if reqBody != nil {
+		entry.Request.PostData = &HARPostData{MimeType: req.Header.Get("Content-Type"), Text: string(reqBody)}
+	}
+	if utf8.Valid(respBody) {
+		entry.Response.Content.Text = string(respBody)
+	} else {
+		entry.Response.Content.Text = base64.StdEncoding.EncodeToString(respBody)
+		entry.Response.Content.Encoding = "base64"
+	}
+
+	hr.mu.Lock()
+	defer hr.mu.Unlock()
+	hr.har.Log.Entries = append(hr.har.Log.Entries, entry)
+	data, err := json.MarshalIndent(hr.har, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(hr.Path, data, 0644); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// HARMatch selects the request fields compared by a HARReplayer, the scheme, host
+// and path are always compared
+type HARMatch struct {
+	Method bool
+	Query  bool
+	Body   bool
+}
+
+// HARNotFoundError is returned when no recorded entry matches a request
+type HARNotFoundError struct {
+	Method string
+	URL    string
+}
+
+func (e *HARNotFoundError) Error() string {
+	return fmt.Sprintf("httpclient: no recorded response for %s %s", e.Method, e.URL)
+}
+
+// HARReplayer defines a http.RoundTripper serving the responses of a HAR file without
+// network access. Entries matching the same request are served in order, the last
+// one is repeated once they are all used.
+type HARReplayer struct {
+	match   HARMatch
+	mu      sync.Mutex
+	entries []HAREntry
+	used    []bool
+}
+
+func NewHARReplayer(path string, match HARMatch) (*HARReplayer, error) {
+	har, err := LoadHAR(path)
+	if err != nil {
+		return nil, err
+	}
+	return &HARReplayer{
+		match:   match,
+		entries: har.Log.Entries,
+		used:    make([]bool, len(har.Log.Entries)),
+	}, nil
+}
+
+func (hr *HARReplayer) matches(entry HAREntry, req *http.Request, body []byte) bool {
+	recorded, err := url.Parse(entry.Request.URL)
+	if err != nil {
+		return false
+	}
+	if recorded.Scheme != req.URL.Scheme || recorded.Host != req.URL.Host || recorded.Path != req.URL.Path {
+		return false
+	}
+	if hr.match.Method && !strings.EqualFold(entry.Request.Method, req.Method) {
+		return false
+	}
+	if hr.match.Query {
+		query := req.URL.Query()
+		for name, values := range recorded.Query() {
+			// redacted values match any value
+			if len(values) == 1 && values[0] == redacted && query.Get(name) != "" {
+				query[name] = values
+			}
+		}
+		if recorded.Query().Encode() != query.Encode() {
+			return false
+		}
+	}
+	if hr.match.Body {
+		text := ""
+		if entry.Request.PostData != nil {
+			text = entry.Request.PostData.Text
+		}
+		if text != string(body) {
+			return false
+		}
+	}
+	return true
+}
+
+func (hr *HARReplayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readBody(&req.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	hr.mu.Lock()
+	found := -1
+	for i, entry := range hr.entries {
+		if !hr.matches(entry, req, body) {
+			continue
+		}
+		found = i
+		if !hr.used[i] {
+			break
+		}
+	}
+	if found >= 0 {
+		hr.used[found] = true
+	}
+	hr.mu.Unlock()
+
+	if found < 0 {
+		return nil, &HARNotFoundError{Method: req.Method, URL: req.URL.String()}
+	}
+	return harResponse(hr.entries[found].Response, req)
+}
+
+func harResponse(r HARResponse, req *http.Request) (*http.Response, error) {
+	body := []byte(r.Content.Text)
+	if r.Content.Encoding == "base64" {
+		decoded, err := base64.StdEncoding.DecodeString(r.Content.Text)
+		if err != nil {
+			return nil, err
+		}
+		body = decoded
+	}
+
+	header := http.Header{}
+	for _, h := range r.Headers {
+		header.Add(h.Name, h.Value)
+	}
+	header.Del("Content-Encoding")
+	header.Set("Content-Length", fmt.Sprint(len(body)))
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", r.Status, r.StatusText),
+		StatusCode:    r.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
@@ -0,0 +1,87 @@
+package httpclient_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	. "github.com/SpazioDati/go-utils/httpclient"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHARRecordReplay(t *testing.T) {
+	assert := assert.New(t)
+	path := filepath.Join(t.TempDir(), "partner.har")
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodPost:
+			w.WriteHeader(http.StatusCreated)
+			w.Write(body)
+		case r.URL.Query().Get("page") == "2":
+			w.Write([]byte(`{"page":2}`))
+		default:
+			w.Write([]byte(`{"page":1}`))
+		}
+	}))
+
+	client := New(Options{
+		HTTPClient:     &http.Client{Transport: NewHARRecorder(path, nil)},
+		Logger:         &logMock{},
+		DisableTracing: true,
+	})
+	_, err := client.R().
+		SetHeader("Authorization", "Bearer secret").
+		SetCookie(&http.Cookie{Name: "session", Value: "secret"}).
+		Get(ts.URL + "/items?page=1&access_token=secret")
+	assert.Nil(err)
+	_, err = client.R().Get(ts.URL + "/items?page=2")
+	assert.Nil(err)
+	_, err = client.R().SetBody(`{"name":"foo"}`).Post(ts.URL + "/items")
+	assert.Nil(err)
+	ts.Close()
+
+	har, err := LoadHAR(path)
+	assert.Nil(err)
+	assert.Equal("1.2", har.Log.Version)
+	assert.Len(har.Log.Entries, 3)
+	assert.Equal(`{"name":"foo"}`, har.Log.Entries[2].Request.PostData.Text)
+	data, _ := ioutil.ReadFile(path)
+	assert.NotContains(string(data), "secret", "credentials are redacted")
+	assert.Contains(har.Log.Entries[0].Request.URL, "access_token=[REDACTED]")
+
+	replayer, err := NewHARReplayer(path, HARMatch{Method: true, Query: true, Body: true})
+	assert.Nil(err)
+	client = New(Options{
+		HTTPClient:     &http.Client{Transport: replayer},
+		Logger:         &logMock{},
+		DisableTracing: true,
+	})
+
+	resp, err := client.R().Get(ts.URL + "/items?page=2")
+	assert.Nil(err)
+	assert.Equal(`{"page":2}`, resp.String())
+	resp, err = client.R().Get(ts.URL + "/items?page=1&access_token=other")
+	assert.Nil(err)
+	assert.Equal(`{"page":1}`, resp.String(), "redacted values match any value")
+	assert.Equal("application/json", resp.Header().Get("Content-Type"))
+
+	resp, err = client.R().SetBody(`{"name":"foo"}`).Post(ts.URL + "/items")
+	assert.Nil(err)
+	assert.Equal(http.StatusCreated, resp.StatusCode())
+
+	_, err = client.R().SetBody(`{"name":"bar"}`).Post(ts.URL + "/items")
+	var notFound *HARNotFoundError
+	assert.True(errors.As(err, &notFound), "bodies are compared")
+
+	replayer, err = NewHARReplayer(path, HARMatch{})
+	assert.Nil(err)
+	client.SetTransport(replayer)
+	resp, err = client.R().Get(ts.URL + "/items?page=3")
+	assert.Nil(err)
+	assert.Equal(`{"page":1}`, resp.String(), "the query is ignored")
+}
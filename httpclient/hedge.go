@@ -0,0 +1,309 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// HedgeOptions configures when a second attempt is sent for a slow GET or HEAD
+type HedgeOptions struct {
+	// Delay is the time to wait for the first attempt before hedging, it is required
+	// without Percentile and defaults to 1s with it
+	Delay time.Duration
+	// Percentile, if set, replaces Delay with the given percentile of the latencies
+	// observed for the host, once MinSamples are available
+	Percentile int
+	// MinSamples is the number of latencies needed before using Percentile, defaults to 20
+	MinSamples int
+}
+
+const hedgeWindow = 100
+
+// latencies is a ring of the last latencies of a host
+type latencies struct {
+	values []time.Duration
+	next   int
+}
+
+// add stores d, replacing the oldest value once window values are kept
+func (l *latencies) add(d time.Duration, window int) {
+	if len(l.values) < window {
+		l.values = append(l.values, d)
+		return
+	}
+	l.values[l.next] = d
+	l.next = (l.next + 1) % window
+}
+
+// percentile returns the nearest-rank percentile p of the values
+func (l *latencies) percentile(p int) time.Duration {
+	sorted := append([]time.Duration{}, l.values...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return percentile(sorted, p)
+}
+
+// Hedger sends a second attempt when the first one is slow and keeps the fastest
+type Hedger struct {
+	opts    HedgeOptions
+	mu      sync.Mutex
+	samples map[string]*latencies
+}
+
+// NewHedger returns a Hedger, a Delay is needed to hedge the requests to the hosts
+// without enough samples
+func NewHedger(opts HedgeOptions) (*Hedger, error) {
+	if opts.Delay <= 0 {
+		if opts.Percentile <= 0 {
+			return nil, errors.New("httpclient: hedger needs a positive delay or a percentile")
+		}
+		opts.Delay = time.Second
+	}
+	if opts.MinSamples <= 0 {
+		opts.MinSamples = 20
+	}
+	return &Hedger{
+		opts:    opts,
+		samples: map[string]*latencies{},
+	}, nil
+}
+
+// Observe stores the latency of a response from host
+func (h *Hedger) Observe(host string, d time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	l, ok := h.samples[host]
+	if !ok {
+		l = &latencies{}
+		h.samples[host] = l
+	}
+	l.add(d, hedgeWindow)
+}
+
+// Delay returns how long to wait before hedging a request to host
+func (h *Hedger) Delay(host string) time.Duration {
+	if h.opts.Percentile <= 0 {
+		return h.opts.Delay
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	l, ok := h.samples[host]
+	if !ok || len(l.values) < h.opts.MinSamples {
+		return h.opts.Delay
+	}
+	return l.percentile(h.opts.Percentile)
+}
+
+type hedgeInfoKey struct{}
+
+// hedgeInfo tells the trace record whether the attempt was hedged
+type hedgeInfo struct {
+	hedged bool
+	won    bool
+}
+
+func hedgeInfoFrom(ctx context.Context) hedgeInfo {
+	if info, ok := ctx.Value(hedgeInfoKey{}).(*hedgeInfo); ok {
+		return *info
+	}
+	return hedgeInfo{}
+}
+
+// OnBeforeRequest returns a middleware letting HedgeTransport report hedged attempts
+func (h *Hedger) OnBeforeRequest() resty.RequestMiddleware {
+	return func(c *resty.Client, r *resty.Request) error {
+		r.SetContext(context.WithValue(r.Context(), hedgeInfoKey{}, &hedgeInfo{}))
+		return nil
+	}
+}
+
+// cancelOnClose cancels the attempt context once the winning body is closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
+type hedgeResult struct {
+	resp   *http.Response
+	err    error
+	hedged bool
+	cancel context.CancelFunc
+}
+
+// clientTraceKey is the context key of the httptrace hooks, read from the lookup
+// done by httptrace.ContextClientTrace
+var clientTraceKey = func() interface{} {
+	rec := &keyRecorder{Context: context.Background()}
+	httptrace.ContextClientTrace(rec)
+	return rec.key
+}()
+
+type keyRecorder struct {
+	context.Context
+	key interface{}
+}
+
+func (k *keyRecorder) Value(key interface{}) interface{} {
+	k.key = key
+	return nil
+}
+
+// valuesOf has the values of a context but never expires, except for the
+// httptrace hooks which record the timings of the first attempt only
+type valuesOf struct {
+	parent context.Context
+}
+
+func (valuesOf) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (valuesOf) Done() <-chan struct{}       { return nil }
+func (valuesOf) Err() error                  { return nil }
+
+func (v valuesOf) Value(key interface{}) interface{} {
+	if key == clientTraceKey {
+		return nil
+	}
+	return v.parent.Value(key)
+}
+
+// detached returns a context with the values, the deadline and the cancellation of
+// parent, which can be canceled on its own: the winning attempt must not be
+// canceled along with the losing one
+func detached(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(valuesOf{parent: parent})
+	if deadline, ok := parent.Deadline(); ok {
+		ctx, cancel = context.WithDeadline(valuesOf{parent: parent}, deadline)
+	}
+	go func() {
+		select {
+		case <-parent.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// HedgeTransport defines a http.RoundTripper hedging GET and HEAD requests. TraceInfo
+// timings always belong to the first attempt.
+type HedgeTransport struct {
+	T      http.RoundTripper
+	Hedger *Hedger
+}
+
+func (ht *HedgeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if (req.Method != http.MethodGet && req.Method != http.MethodHead) || (req.Body != nil && req.Body != http.NoBody) {
+		return ht.T.RoundTrip(req)
+	}
+
+	host := req.URL.Host
+	start := time.Now()
+	results := make(chan hedgeResult, 2)
+	launch := func(r *http.Request, hedged bool, cancel context.CancelFunc) {
+		go func() {
+			resp, err := ht.T.RoundTrip(r)
+			results <- hedgeResult{resp: resp, err: err, hedged: hedged, cancel: cancel}
+		}()
+	}
+
+	ctx, cancel := context.WithCancel(req.Context())
+	launch(req.WithContext(ctx), false, cancel)
+	cancels := []context.CancelFunc{cancel}
+	pending := 1
+	hedged := false
+
+	timer := time.NewTimer(ht.Hedger.Delay(host))
+	defer timer.Stop()
+
+	var last hedgeResult
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			hctx, hcancel := detached(req.Context())
+			launch(req.Clone(hctx), true, hcancel)
+			cancels = append(cancels, hcancel)
+			pending++
+			hedged = true
+		case res := <-results:
+			pending--
+			if res.err != nil {
+				last = res
+				if !hedged {
+					// failed before hedging, let the retry policy decide
+					timer.Stop()
+					pending = 0
+				}
+				continue
+			}
+
+			ht.Hedger.Observe(host, time.Since(start))
+			ht.report(req.Context(), hedged, res.hedged)
+			for i, c := range cancels {
+				// the winner context lives until its body is closed
+				if i != winner(res.hedged) {
+					c()
+				}
+			}
+			if pending > 0 {
+				go drain(results, pending)
+			}
+			res.resp.Body = &cancelOnClose{ReadCloser: res.resp.Body, cancel: res.cancel}
+			return res.resp, nil
+		}
+	}
+
+	ht.report(req.Context(), hedged, false)
+	for _, c := range cancels {
+		c()
+	}
+	return last.resp, last.err
+}
+
+// winner returns the index of the winning attempt in the cancel functions
+func winner(hedged bool) int {
+	if hedged {
+		return 1
+	}
+	return 0
+}
+
+func (ht *HedgeTransport) report(ctx context.Context, hedged, won bool) {
+	if !hedged {
+		return
+	}
+	if info, ok := ctx.Value(hedgeInfoKey{}).(*hedgeInfo); ok {
+		info.hedged = true
+		info.won = won
+	}
+	attemptSpan(ctx).SetAttributes(
+		attribute.Bool("http.hedged", true),
+		attribute.Bool("http.hedge_won", won),
+	)
+}
+
+// drain closes the responses of the losing attempts
+func drain(results chan hedgeResult, pending int) {
+	for i := 0; i < pending; i++ {
+		res := <-results
+		if res.resp != nil && res.resp.Body != nil {
+			res.resp.Body.Close()
+		}
+		res.cancel()
+	}
+}
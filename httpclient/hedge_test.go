@@ -0,0 +1,118 @@
+package httpclient_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/SpazioDati/go-utils/httpclient"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHedgerDelay(t *testing.T) {
+	assert := assert.New(t)
+	hedger, err := NewHedger(HedgeOptions{Percentile: 90, MinSamples: 10})
+	assert.Nil(err)
+
+	for i := 1; i <= 9; i++ {
+		hedger.Observe("foo", time.Duration(i)*time.Millisecond)
+	}
+	assert.Equal(time.Second, hedger.Delay("foo"), "not enough samples")
+
+	hedger.Observe("foo", 10*time.Millisecond)
+	assert.Equal(9*time.Millisecond, hedger.Delay("foo"))
+	assert.Equal(time.Second, hedger.Delay("bar"), "samples are per host")
+
+	_, err = NewHedger(HedgeOptions{})
+	assert.EqualError(err, "httpclient: hedger needs a positive delay or a percentile")
+}
+
+func TestHedgeTransport(t *testing.T) {
+	assert := assert.New(t)
+	defer DisableTrace()
+	var calls int32
+	unblock := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			select {
+			case <-unblock:
+			case <-r.Context().Done():
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+	defer close(unblock)
+	host := strings.TrimPrefix(ts.URL, "http://")
+
+	hedger, err := NewHedger(HedgeOptions{Delay: 20 * time.Millisecond})
+	assert.Nil(err)
+	client := New(Options{
+		HTTPClient: &http.Client{},
+		Logger:     &logMock{},
+		Hedger:     hedger,
+	})
+
+	EnableTrace(time.Minute)
+	resp, err := client.R().Get(ts.URL + "/hedged")
+	assert.Nil(err)
+	assert.Equal(http.StatusOK, resp.StatusCode())
+	assert.Equal(int32(2), atomic.LoadInt32(&calls))
+
+	records := DefaultTraceBuffer.Records(TraceFilter{Host: host})
+	last := records[len(records)-1]
+	assert.True(last.Hedged)
+	assert.True(last.HedgeWon)
+
+	_, err = client.R().Post(ts.URL)
+	assert.Nil(err)
+	assert.Equal(int32(3), atomic.LoadInt32(&calls), "only GET and HEAD are hedged")
+	records = DefaultTraceBuffer.Records(TraceFilter{Host: host})
+	assert.False(records[len(records)-1].Hedged)
+}
+
+func TestHedgeBreaker(t *testing.T) {
+	assert := assert.New(t)
+	unblock := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-unblock:
+		case <-r.Context().Done():
+		}
+	}))
+	defer slow.Close()
+	defer close(unblock)
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("fast"))
+	}))
+	defer fast.Close()
+
+	balancer, err := NewBalancer(nil, BalancerOptions{
+		Endpoints: []string{slow.URL, fast.URL},
+		Strategy:  PriorityFailover,
+	})
+	assert.Nil(err)
+	breaker := NewBreaker(nil, BreakerOptions{FailureThreshold: 1, OpenTimeout: time.Minute})
+	hedger, err := NewHedger(HedgeOptions{Delay: 20 * time.Millisecond})
+	assert.Nil(err)
+	client := New(Options{
+		HTTPClient:     &http.Client{},
+		Logger:         &logMock{},
+		Timeout:        2 * time.Second,
+		DisableTracing: true,
+		Breaker:        breaker,
+		Balancer:       balancer,
+		Hedger:         hedger,
+	})
+
+	for i := 0; i < 3; i++ {
+		resp, err := client.R().Get("/")
+		assert.Nil(err)
+		assert.Equal("fast", resp.String(), "the hedge goes to the endpoint not tried yet")
+	}
+	assert.Equal(StateClosed, breaker.State(strings.TrimPrefix(slow.URL, "http://")),
+		"the canceled losers are not failures")
+}
@@ -0,0 +1,208 @@
+package httpclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-resty/resty/v2"
+)
+
+// HDRIdempotencyKey is the header identifying a logical request across retries
+const HDRIdempotencyKey = "Idempotency-Key"
+
+type idempotencyKeyKey struct{}
+
+// IdempotencyKey returns a middleware adding an Idempotency-Key to POST and PATCH
+// requests when retries are enabled. The key is kept in the request context, so every
+// retry attempt carries the same key while a resty.Request sent again with its first
+// context, as by Paginator and Download, gets a new one.
+func IdempotencyKey() resty.RequestMiddleware {
+	return func(c *resty.Client, r *resty.Request) error {
+		if key, ok := r.Context().Value(idempotencyKeyKey{}).(string); ok {
+			r.SetHeader(HDRIdempotencyKey, key)
+			return nil
+		}
+		if c.RetryCount <= 0 || r.Header.Get(HDRIdempotencyKey) != "" {
+			return nil
+		}
+		if r.Method != http.MethodPost && r.Method != http.MethodPatch {
+			return nil
+		}
+
+		key, err := newKey()
+		if err != nil {
+			return err
+		}
+		r.SetHeader(HDRIdempotencyKey, key)
+		r.SetContext(context.WithValue(r.Context(), idempotencyKeyKey{}, key))
+		return nil
+	}
+}
+
+// newKey returns a random UUID v4
+func newKey() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// IdempotencyRecord is the response stored for an Idempotency-Key
+type IdempotencyRecord struct {
+	Done   bool
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// IdempotencyStore keeps track of the keys seen by IdempotencyMW
+type IdempotencyStore interface {
+	// Reserve marks key as in progress. If key is already known its record is
+	// returned along with true and nothing is changed.
+	Reserve(key string, ttl time.Duration) (IdempotencyRecord, bool)
+	// Complete stores the response for a reserved key
+	Complete(key string, record IdempotencyRecord, ttl time.Duration)
+	// Release forgets a reserved key so the request can be sent again
+	Release(key string)
+}
+
+type memoryEntry struct {
+	record  IdempotencyRecord
+	expires time.Time
+}
+
+// idempotencySweepInterval is how often a MemoryIdempotencyStore drops its expired
+// entries
+const idempotencySweepInterval = time.Minute
+
+// MemoryIdempotencyStore is an in-memory IdempotencyStore, entries expire after their ttl
+type MemoryIdempotencyStore struct {
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+	// nextSweep is when the expired entries are dropped next
+	nextSweep time.Time
+}
+
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{entries: map[string]memoryEntry{}}
+}
+
+func (s *MemoryIdempotencyStore) Reserve(key string, ttl time.Duration) (IdempotencyRecord, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.sweep(now)
+
+	if e, ok := s.entries[key]; ok && !now.After(e.expires) {
+		return e.record, true
+	}
+	s.entries[key] = memoryEntry{expires: now.Add(ttl)}
+	return IdempotencyRecord{}, false
+}
+
+// sweep drops the expired entries, at most once per idempotencySweepInterval, must be
+// called with s.mu held
+func (s *MemoryIdempotencyStore) sweep(now time.Time) {
+	if now.Before(s.nextSweep) {
+		return
+	}
+	s.nextSweep = now.Add(idempotencySweepInterval)
+	for k, e := range s.entries {
+		if now.After(e.expires) {
+			delete(s.entries, k)
+		}
+	}
+}
+
+func (s *MemoryIdempotencyStore) Complete(key string, record IdempotencyRecord, ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record.Done = true
+	s.entries[key] = memoryEntry{record: record, expires: time.Now().Add(ttl)}
+}
+
+func (s *MemoryIdempotencyStore) Release(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, key)
+}
+
+// recorder copies the response body while it is written
+type recorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *recorder) WriteString(s string) (int, error) {
+	r.body.WriteString(s)
+	return r.ResponseWriter.WriteString(s)
+}
+
+// IdempotencyMW dedupes requests carrying an already seen Idempotency-Key: the stored
+// response is replayed, or 409 is returned while the first request is in progress.
+// 5xx responses are not stored so the client can retry them.
+func IdempotencyMW(store IdempotencyStore, ttl time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(HDRIdempotencyKey)
+		if key == "" {
+			c.Next()
+			return
+		}
+		key = fmt.Sprintf("%s %s %s", c.Request.Method, c.Request.URL.Path, key)
+
+		if record, ok := store.Reserve(key, ttl); ok {
+			if !record.Done {
+				c.AbortWithStatus(http.StatusConflict)
+				return
+			}
+			for k, values := range record.Header {
+				for _, v := range values {
+					c.Writer.Header().Add(k, v)
+				}
+			}
+			c.Writer.WriteHeader(record.Status)
+			_, _ = c.Writer.Write(record.Body)
+			c.Abort()
+			return
+		}
+
+		completed := false
+		defer func() {
+			// the handler panicked or failed: let the client try again
+			if !completed {
+				store.Release(key)
+			}
+		}()
+
+		rec := &recorder{ResponseWriter: c.Writer}
+		c.Writer = rec
+		c.Next()
+
+		if rec.Status() >= http.StatusInternalServerError {
+			return
+		}
+		completed = true
+		store.Complete(key, IdempotencyRecord{
+			Status: rec.Status(),
+			Header: rec.Header().Clone(),
+			Body:   rec.body.Bytes(),
+		}, ttl)
+	}
+}
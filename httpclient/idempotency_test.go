@@ -0,0 +1,119 @@
+package httpclient_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/SpazioDati/go-utils/httpclient"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdempotencyKey(t *testing.T) {
+	assert := assert.New(t)
+	keys := []string{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys = append(keys, r.Header.Get(HDRIdempotencyKey))
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	client := New(Options{
+		HTTPClient: &http.Client{},
+		Logger:     &logMock{},
+		Retries:    2,
+	})
+	client.SetRetryWaitTime(time.Millisecond)
+
+	policy := DefaultRetryPolicy()
+	policy.Methods = append(policy.Methods, http.MethodPost)
+	_, _ = client.R().
+		SetContext(WithRetryPolicy(context.Background(), policy)).
+		Post(ts.URL)
+
+	assert.Equal(3, len(keys))
+	assert.Len(keys[0], 36)
+	assert.Equal(keys[0], keys[1], "the key is reused on every attempt")
+	assert.Equal(keys[0], keys[2], "the key is reused on every attempt")
+
+	keys = nil
+	_, _ = client.R().Post(ts.URL)
+	_, _ = client.R().Get(ts.URL)
+	assert.NotEmpty(keys[0])
+	assert.Empty(keys[len(keys)-1], "GET requests have no key")
+
+	keys = nil
+	_, _ = New(Options{HTTPClient: &http.Client{}, Logger: &logMock{}}).R().Post(ts.URL)
+	assert.Empty(keys[0], "no key without retries")
+}
+
+func TestIdempotencyMW(t *testing.T) {
+	assert := assert.New(t)
+	calls := 0
+	r := gin.New()
+	r.Use(IdempotencyMW(NewMemoryIdempotencyStore(), time.Minute))
+	r.POST("/", func(c *gin.Context) {
+		calls++
+		c.Header("X-Call", "first")
+		c.String(http.StatusCreated, "created")
+	})
+	r.POST("/fail", func(c *gin.Context) {
+		calls++
+		c.String(http.StatusInternalServerError, "boom")
+	})
+
+	do := func(path, key string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", path, nil)
+		if key != "" {
+			req.Header.Set(HDRIdempotencyKey, key)
+		}
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	for i := 0; i < 3; i++ {
+		w := do("/", "foo")
+		assert.Equal(http.StatusCreated, w.Code)
+		assert.Equal("created", w.Body.String())
+		assert.Equal("first", w.Header().Get("X-Call"))
+	}
+	assert.Equal(1, calls, "replayed from the store")
+
+	do("/", "bar")
+	do("/", "")
+	do("/", "")
+	assert.Equal(4, calls)
+
+	calls = 0
+	do("/fail", "foo")
+	do("/fail", "foo")
+	assert.Equal(2, calls, "5xx responses are not stored")
+}
+
+func TestMemoryIdempotencyStore(t *testing.T) {
+	assert := assert.New(t)
+	store := NewMemoryIdempotencyStore()
+
+	_, ok := store.Reserve("foo", time.Minute)
+	assert.False(ok)
+	record, ok := store.Reserve("foo", time.Minute)
+	assert.True(ok)
+	assert.False(record.Done, "in progress")
+
+	store.Complete("foo", IdempotencyRecord{Status: http.StatusOK}, time.Millisecond)
+	record, ok = store.Reserve("foo", time.Minute)
+	assert.True(ok)
+	assert.True(record.Done)
+
+	time.Sleep(5 * time.Millisecond)
+	_, ok = store.Reserve("foo", time.Minute)
+	assert.False(ok, "expired")
+
+	store.Release("foo")
+	_, ok = store.Reserve("foo", time.Minute)
+	assert.False(ok, "released")
+}
@@ -0,0 +1,126 @@
+package httpclient
+
+import (
+	"errors"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/global"
+	oteltrace "go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/unit"
+)
+
+const instrumentationName = "github.com/SpazioDati/go-utils/httpclient"
+
+// Metrics records the connection phases found in resty.TraceInfo as OpenTelemetry
+// instruments, labeled by host and error_kind (see ErrorKind), and as events on the
+// span of the request context
+type Metrics struct {
+	dnsLookup    metric.Float64ValueRecorder
+	tcpConnect   metric.Float64ValueRecorder
+	tlsHandshake metric.Float64ValueRecorder
+	serverTime   metric.Float64ValueRecorder
+	connections  metric.Int64Counter
+}
+
+// NewMetrics creates the instruments on meter
+func NewMetrics(meter metric.Meter) *Metrics {
+	must := metric.Must(meter)
+	ms := metric.WithUnit(unit.Milliseconds)
+	return &Metrics{
+		dnsLookup: must.NewFloat64ValueRecorder(
+			"http.client.dns_lookup", ms,
+			metric.WithDescription("Time spent resolving the host name"),
+		),
+		tcpConnect: must.NewFloat64ValueRecorder(
+			"http.client.tcp_connect", ms,
+			metric.WithDescription("Time spent opening the TCP connection"),
+		),
+		tlsHandshake: must.NewFloat64ValueRecorder(
+			"http.client.tls_handshake", ms,
+			metric.WithDescription("Time spent in the TLS handshake"),
+		),
+		serverTime: must.NewFloat64ValueRecorder(
+			"http.client.server_time", ms,
+			metric.WithDescription("Time between the connection and the first response byte"),
+		),
+		connections: must.NewInt64Counter(
+			"http.client.connections",
+			metric.WithDescription("Connections used by requests, labeled by reuse"),
+		),
+	}
+}
+
+// NewGlobalMetrics creates the instruments on the global meter, see opentelemetry.Init
+func NewGlobalMetrics() *Metrics {
+	return NewMetrics(global.Meter(instrumentationName))
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// Record stores the timings of the last attempt of r. Trace must be enabled on r for
+// the timings to be there.
+func (m *Metrics) Record(r *resty.Request) {
+	m.record(r, 0, nil)
+}
+
+// record labels the timings with the ErrorKind of status and err, if any
+func (m *Metrics) record(r *resty.Request, status int, err error) {
+	ti := r.TraceInfo()
+	if ti.TotalTime == 0 && ti.ConnTime == 0 {
+		// nothing was traced
+		return
+	}
+
+	ctx := r.Context()
+	host := ""
+	if u := requestURL(nil, r); u != nil {
+		host = u.Host
+	}
+	kind := ErrorKind(status, err)
+	labels := []attribute.KeyValue{attribute.String("host", host), attribute.String("error_kind", kind)}
+
+	m.dnsLookup.Record(ctx, milliseconds(ti.DNSLookup), labels...)
+	m.tcpConnect.Record(ctx, milliseconds(ti.TCPConnTime), labels...)
+	m.tlsHandshake.Record(ctx, milliseconds(ti.TLSHandshake), labels...)
+	m.serverTime.Record(ctx, milliseconds(ti.ServerTime), labels...)
+	m.connections.Add(ctx, 1, append(labels, attribute.Bool("reused", ti.IsConnReused))...)
+
+	oteltrace.SpanFromContext(ctx).AddEvent(
+		"http.client.timings",
+		oteltrace.WithAttributes(
+			attribute.String("host", host),
+			attribute.Int("attempt", r.Attempt),
+			attribute.Float64("dns_lookup_ms", milliseconds(ti.DNSLookup)),
+			attribute.Float64("tcp_connect_ms", milliseconds(ti.TCPConnTime)),
+			attribute.Float64("tls_handshake_ms", milliseconds(ti.TLSHandshake)),
+			attribute.Float64("server_time_ms", milliseconds(ti.ServerTime)),
+			attribute.Bool("conn_reused", ti.IsConnReused),
+			attribute.String("error_kind", kind),
+		),
+	)
+}
+
+// OnAfterResponse returns a middleware recording every attempt that got a response
+func (m *Metrics) OnAfterResponse() resty.ResponseMiddleware {
+	return func(c *resty.Client, r *resty.Response) error {
+		m.record(r.Request, r.StatusCode(), nil)
+		return nil
+	}
+}
+
+// OnError returns a hook recording requests that failed
+func (m *Metrics) OnError() resty.ErrorHook {
+	return func(r *resty.Request, err error) {
+		var respErr *resty.ResponseError
+		if errors.As(err, &respErr) && respErr.Response.RawResponse != nil {
+			// already recorded by OnAfterResponse
+			return
+		}
+		m.record(r, 0, err)
+	}
+}
@@ -0,0 +1,121 @@
+package httpclient_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/SpazioDati/go-utils/httpclient"
+	"github.com/stretchr/testify/assert"
+	export "go.opentelemetry.io/otel/sdk/export/metric"
+	controller "go.opentelemetry.io/otel/sdk/metric/controller/basic"
+	processor "go.opentelemetry.io/otel/sdk/metric/processor/basic"
+	"go.opentelemetry.io/otel/sdk/metric/selector/simple"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+type spanRecorder struct {
+	ended []sdktrace.ReadOnlySpan
+}
+
+func (s *spanRecorder) OnStart(context.Context, sdktrace.ReadWriteSpan) {}
+func (s *spanRecorder) OnEnd(span sdktrace.ReadOnlySpan)                { s.ended = append(s.ended, span) }
+func (s *spanRecorder) Shutdown(context.Context) error                  { return nil }
+func (s *spanRecorder) ForceFlush()                                     {}
+
+func TestMetrics(t *testing.T) {
+	assert := assert.New(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/broken" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	cont := controller.New(processor.New(
+		simple.NewWithExactDistribution(),
+		export.CumulativeExportKindSelector(),
+	))
+	spans := &spanRecorder{}
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans))
+
+	client := New(Options{
+		HTTPClient: &http.Client{},
+		Logger:     &logMock{},
+		Metrics:    NewMetrics(cont.MeterProvider().Meter("test")),
+	})
+
+	ctx, span := tp.Tracer("test").Start(context.Background(), "parent")
+	_, err := client.R().SetContext(ctx).Get(ts.URL)
+	assert.Nil(err)
+	span.End()
+	_, err = client.R().Get(ts.URL + "/broken")
+	assert.Nil(err)
+
+	assert.Nil(cont.Collect(context.Background()))
+	names := map[string]bool{}
+	kinds := map[string]bool{}
+	assert.Nil(cont.ForEach(export.CumulativeExportKindSelector(), func(r export.Record) error {
+		names[r.Descriptor().Name()] = true
+		host, ok := r.Labels().Value("host")
+		assert.True(ok)
+		assert.NotEmpty(host.AsString())
+		kind, _ := r.Labels().Value("error_kind")
+		kinds[kind.AsString()] = true
+		return nil
+	}))
+	assert.Equal(map[string]bool{"": true, "server_error": true}, kinds)
+	for _, name := range []string{
+		"http.client.dns_lookup",
+		"http.client.tcp_connect",
+		"http.client.tls_handshake",
+		"http.client.server_time",
+		"http.client.connections",
+	} {
+		assert.True(names[name], name)
+	}
+
+	assert.Equal(1, len(spans.ended))
+	events := spans.ended[0].Events()
+	assert.Equal(1, len(events))
+	assert.Equal("http.client.timings", events[0].Name)
+}
+
+func TestMetricsTracing(t *testing.T) {
+	assert := assert.New(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	cont := controller.New(processor.New(
+		simple.NewWithExactDistribution(),
+		export.CumulativeExportKindSelector(),
+	))
+	spans := &spanRecorder{}
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans))
+
+	client := New(Options{
+		HTTPClient:     &http.Client{},
+		Logger:         &logMock{},
+		Metrics:        NewMetrics(cont.MeterProvider().Meter("test")),
+		TracerProvider: tp,
+	})
+
+	ctx, span := tp.Tracer("test").Start(context.Background(), "parent")
+	_, err := client.R().SetContext(ctx).Get(ts.URL)
+	assert.Nil(err)
+	span.End()
+
+	if !assert.Equal(2, len(spans.ended)) {
+		return
+	}
+	attempt, parent := spans.ended[0], spans.ended[1]
+	assert.Equal("HTTP GET", attempt.Name())
+	assert.Equal(1, len(attempt.Events()), "events land on the span of the attempt")
+	assert.Equal("http.client.timings", attempt.Events()[0].Name)
+	assert.Empty(parent.Events())
+}
@@ -0,0 +1,172 @@
+package httpclient
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SpazioDati/go-utils/opentelemetry"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	oteltrace "go.opentelemetry.io/otel/trace"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// ClientCredentialsOptions configures the OAuth2 client credentials grant
+type ClientCredentialsOptions struct {
+	TokenURL       string
+	ClientID       string
+	ClientSecret   string
+	Scopes         []string
+	EndpointParams url.Values
+	// ExpiryMargin is how long before its expiry a token is refreshed, defaults to 30s
+	ExpiryMargin time.Duration
+	// HTTPClient fetches the tokens, defaults to http.DefaultClient
+	HTTPClient *http.Client
+}
+
+// ClientCredentials caches the token of an OAuth2 client, it can be shared by many
+// clients. Concurrent callers wait for a single token request.
+type ClientCredentials struct {
+	opts   ClientCredentialsOptions
+	config clientcredentials.Config
+	mu     sync.Mutex
+	token  *oauth2.Token
+}
+
+func NewClientCredentials(opts ClientCredentialsOptions) *ClientCredentials {
+	if opts.ExpiryMargin <= 0 {
+		opts.ExpiryMargin = 30 * time.Second
+	}
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = http.DefaultClient
+	}
+	return &ClientCredentials{
+		opts: opts,
+		config: clientcredentials.Config{
+			ClientID:       opts.ClientID,
+			ClientSecret:   opts.ClientSecret,
+			TokenURL:       opts.TokenURL,
+			Scopes:         opts.Scopes,
+			EndpointParams: opts.EndpointParams,
+		},
+	}
+}
+
+func (cc *ClientCredentials) valid() bool {
+	if cc.token == nil || cc.token.AccessToken == "" {
+		return false
+	}
+	return cc.token.Expiry.IsZero() || time.Now().Add(cc.opts.ExpiryMargin).Before(cc.token.Expiry)
+}
+
+// Token returns a cached access token, fetching a new one when missing or about to
+// expire. The request is traced as a child of ctx.
+func (cc *ClientCredentials) Token(ctx context.Context) (string, error) {
+	return cc.tracedToken(ctx, nil)
+}
+
+// tracedToken is Token with the fetch traced by tracer, or by the tracer set up by
+// opentelemetry.Init when nil
+func (cc *ClientCredentials) tracedToken(ctx context.Context, tracer oteltrace.Tracer) (string, error) {
+	if tracer == nil {
+		tracer = opentelemetry.GetTracer()
+	}
+
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	if cc.valid() {
+		return cc.token.AccessToken, nil
+	}
+
+	ctx, span := tracer.Start(
+		ctx,
+		"OAuth2 token",
+		oteltrace.WithSpanKind(oteltrace.SpanKindClient),
+		oteltrace.WithAttributes(attribute.String("oauth2.token_url", cc.opts.TokenURL)),
+	)
+	defer span.End()
+
+	start := time.Now()
+	token, err := cc.config.Token(context.WithValue(ctx, oauth2.HTTPClient, cc.opts.HTTPClient))
+	elapsed := time.Since(start)
+	span.SetAttributes(attribute.Float64("oauth2.fetch_ms", milliseconds(elapsed)))
+	attemptSpan(ctx).SetAttributes(attribute.Float64("http.auth.token_fetch_ms", milliseconds(elapsed)))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return "", fmt.Errorf("httpclient: fetching oauth2 token from %s: %w", cc.opts.TokenURL, err)
+	}
+
+	cc.token = token
+	return token.AccessToken, nil
+}
+
+// identity tells apart the credentials of different clients, the secret aside
+func (cc *ClientCredentials) identity() string {
+	return strings.Join(append([]string{cc.opts.TokenURL, cc.opts.ClientID}, cc.opts.Scopes...), " ")
+}
+
+// Invalidate drops the cached token if it is still accessToken, a token refreshed
+// meanwhile is kept
+func (cc *ClientCredentials) Invalidate(accessToken string) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	if cc.token != nil && cc.token.AccessToken == accessToken {
+		cc.token = nil
+	}
+}
+
+// OAuth2Transport defines a http.RoundTripper authenticating requests with a bearer
+// token. On a 401 the token is invalidated and the request is sent once more with a
+// new one, if its body can be replayed.
+type OAuth2Transport struct {
+	T           http.RoundTripper
+	Credentials *ClientCredentials
+	// Tracer traces the token requests, defaults to the tracer set up by
+	// opentelemetry.Init
+	Tracer oteltrace.Tracer
+}
+
+func (ot *OAuth2Transport) send(req *http.Request) (*http.Response, string, error) {
+	token, err := ot.Credentials.tracedToken(req.Context(), ot.Tracer)
+	if err != nil {
+		return nil, "", err
+	}
+	authenticated := req.Clone(req.Context())
+	authenticated.Header.Set("Authorization", "Bearer "+token)
+	resp, err := ot.T.RoundTrip(authenticated)
+	return resp, token, err
+}
+
+func (ot *OAuth2Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, token, err := ot.send(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	replayable := req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+	ot.Credentials.Invalidate(token)
+	if !replayable {
+		return resp, nil
+	}
+	resp.Body.Close()
+
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		req = req.Clone(req.Context())
+		req.Body = body
+	}
+	resp, _, err = ot.send(req)
+	return resp, err
+}
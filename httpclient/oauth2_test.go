@@ -0,0 +1,158 @@
+package httpclient_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/SpazioDati/go-utils/httpclient"
+	"github.com/stretchr/testify/assert"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// tokenServer issues "token-N" tokens valid for expiresIn seconds
+func tokenServer(fetches *int32, expiresIn int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id, secret, ok := r.BasicAuth(); !ok || id != "client" || secret != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		n := atomic.AddInt32(fetches, 1)
+		time.Sleep(10 * time.Millisecond)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token":"token-%d","token_type":"bearer","expires_in":%d}`, n, expiresIn)
+	}))
+}
+
+func TestOAuth2(t *testing.T) {
+	assert := assert.New(t)
+	var fetches int32
+	tokens := tokenServer(&fetches, 3600)
+	defer tokens.Close()
+
+	var valid atomic.Value
+	valid.Store("Bearer token-1")
+	bodies := []string{}
+	mu := sync.Mutex{}
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != valid.Load().(string) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		bodies = append(bodies, string(body))
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer api.Close()
+
+	client := New(Options{
+		HTTPClient:     &http.Client{},
+		Logger:         &logMock{},
+		DisableTracing: true,
+		OAuth2: NewClientCredentials(ClientCredentialsOptions{
+			TokenURL:     tokens.URL,
+			ClientID:     "client",
+			ClientSecret: "secret",
+		}),
+	})
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.R().Get(api.URL)
+			assert.Nil(err)
+			assert.Equal(http.StatusOK, resp.StatusCode())
+		}()
+	}
+	wg.Wait()
+	assert.Equal(int32(1), atomic.LoadInt32(&fetches), "a single token request")
+
+	valid.Store("Bearer token-2")
+	resp, err := client.R().SetBody("payload").Post(api.URL)
+	assert.Nil(err)
+	assert.Equal(http.StatusOK, resp.StatusCode(), "a rejected token is replaced once")
+	assert.Equal(int32(2), atomic.LoadInt32(&fetches))
+	assert.Equal("payload", bodies[len(bodies)-1], "the body is sent again")
+
+	valid.Store("Bearer nobody")
+	resp, err = client.R().Get(api.URL)
+	assert.Nil(err)
+	assert.Equal(http.StatusUnauthorized, resp.StatusCode(), "no second retry")
+	assert.Equal(int32(3), atomic.LoadInt32(&fetches))
+}
+
+func TestOAuth2Expiry(t *testing.T) {
+	assert := assert.New(t)
+	var fetches int32
+	tokens := tokenServer(&fetches, 10)
+	defer tokens.Close()
+
+	credentials := NewClientCredentials(ClientCredentialsOptions{
+		TokenURL:     tokens.URL,
+		ClientID:     "client",
+		ClientSecret: "secret",
+	})
+	for i := 0; i < 2; i++ {
+		token, err := credentials.Token(context.Background())
+		assert.Nil(err)
+		assert.Equal(fmt.Sprintf("token-%d", i+1), token, "tokens within the margin are refreshed")
+	}
+
+	credentials = NewClientCredentials(ClientCredentialsOptions{
+		TokenURL:     tokens.URL,
+		ClientID:     "client",
+		ClientSecret: "secret",
+		ExpiryMargin: time.Second,
+	})
+	token, _ := credentials.Token(context.Background())
+	assert.Equal("token-3", token)
+	credentials.Invalidate("token-1")
+	token, _ = credentials.Token(context.Background())
+	assert.Equal("token-3", token, "only the rejected token is dropped")
+	credentials.Invalidate("token-3")
+	token, _ = credentials.Token(context.Background())
+	assert.Equal("token-4", token)
+
+	bad := NewClientCredentials(ClientCredentialsOptions{TokenURL: tokens.URL, ClientID: "client"})
+	_, err := bad.Token(context.Background())
+	assert.NotNil(err)
+}
+
+func TestOAuth2Tracing(t *testing.T) {
+	assert := assert.New(t)
+	var fetches int32
+	tokens := tokenServer(&fetches, 3600)
+	defer tokens.Close()
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer api.Close()
+
+	spans := &spanRecorder{}
+	client := New(Options{
+		HTTPClient:     &http.Client{},
+		Logger:         &logMock{},
+		TracerProvider: sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)),
+		OAuth2: NewClientCredentials(ClientCredentialsOptions{
+			TokenURL:     tokens.URL,
+			ClientID:     "client",
+			ClientSecret: "secret",
+		}),
+	})
+	_, err := client.R().Get(api.URL)
+	assert.Nil(err)
+
+	names := []string{}
+	for _, span := range spans.ended {
+		names = append(names, span.Name())
+	}
+	assert.Equal([]string{"OAuth2 token", "HTTP GET"}, names, "the token is fetched by the client tracer")
+}
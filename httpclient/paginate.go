@@ -0,0 +1,331 @@
+package httpclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// PageStyle tells a Paginator how an API links its pages
+type PageStyle int
+
+const (
+	// LinkPages follows the RFC 8288 Link header with rel="next"
+	LinkPages PageStyle = iota
+	// CursorPages sends back the cursor found at CursorPath in each page
+	CursorPages
+	// OffsetPages moves the offset parameter by the number of items of each page
+	OffsetPages
+)
+
+// PaginatorOptions describes a paginated API
+type PaginatorOptions struct {
+	Style PageStyle
+	// Method defaults to GET
+	Method string
+	// ItemsPath is the dotted path of the items array in the JSON body, e.g.
+	// "data.items", empty means the body itself
+	ItemsPath string
+	// CursorPath is the dotted path of the next cursor in the JSON body, e.g.
+	// "meta.next_cursor"; a missing, null or empty cursor ends the pages
+	CursorPath string
+	// CursorParam is the query parameter carrying the cursor, defaults to "cursor"
+	CursorParam string
+	// OffsetParam and LimitParam default to "offset" and "limit"
+	OffsetParam string
+	LimitParam  string
+	// Limit is the page size requested with offsets, defaults to 100
+	Limit int
+	// MaxPages stops the iteration after that many pages, zero means no limit
+	MaxPages int
+}
+
+// Page is a response of a paginated API
+type Page struct {
+	// Number starts from 1
+	Number   int
+	Response *resty.Response
+	Items    []json.RawMessage
+}
+
+// Paginator walks through the pages of an API, sending the same resty.Request
+// once per page so that every page goes through the retries, the tracing and the
+// rate limiting of its client. The iteration ends on an empty page, after MaxPages
+// or when the request context is done:
+//
+//	pages := NewPaginator(client.R().SetContext(ctx), "/users", opts)
+//	for pages.Next() {
+//		for _, item := range pages.Page().Items { ... }
+//	}
+//	if err := pages.Err(); err != nil { ... }
+type Paginator struct {
+	req    *resty.Request
+	ctx    context.Context
+	opts   PaginatorOptions
+	url    string
+	offset int
+	page   *Page
+	err    error
+	done   bool
+}
+
+// NewPaginator returns a Paginator for the pages of url, starting with the query
+// parameters of req
+func NewPaginator(req *resty.Request, url string, opts PaginatorOptions) *Paginator {
+	if opts.Method == "" {
+		opts.Method = resty.MethodGet
+	}
+	if opts.CursorParam == "" {
+		opts.CursorParam = "cursor"
+	}
+	if opts.OffsetParam == "" {
+		opts.OffsetParam = "offset"
+	}
+	if opts.LimitParam == "" {
+		opts.LimitParam = "limit"
+	}
+	if opts.Limit <= 0 {
+		opts.Limit = 100
+	}
+
+	p := &Paginator{
+		req:  req,
+		ctx:  req.Context(),
+		opts: opts,
+		url:  url,
+	}
+	if opts.Style == OffsetPages {
+		p.offset, _ = strconv.Atoi(req.QueryParam.Get(opts.OffsetParam))
+		req.SetQueryParam(opts.LimitParam, strconv.Itoa(opts.Limit))
+	}
+	return p
+}
+
+// Next fetches the next page, it returns false once the pages are over or on error
+func (p *Paginator) Next() bool {
+	if p.done {
+		return false
+	}
+	if p.opts.MaxPages > 0 && p.number() >= p.opts.MaxPages {
+		return p.stop(nil)
+	}
+	if err := p.ctx.Err(); err != nil {
+		return p.stop(err)
+	}
+
+	if p.opts.Style == OffsetPages {
+		p.req.SetQueryParam(p.opts.OffsetParam, strconv.Itoa(p.offset))
+	}
+	rewind(p.req, p.ctx)
+	resp, err := p.req.Execute(p.opts.Method, p.url)
+	if err = AsError(resp, err); err != nil {
+		return p.stop(err)
+	}
+
+	body := json.RawMessage(bytes.TrimSpace(resp.Body()))
+	if len(body) > 0 && !json.Valid(body) {
+		return p.stop(fmt.Errorf("httpclient: paginator: page %d is not valid JSON", p.number()+1))
+	}
+	items, err := pageItems(body, p.opts.ItemsPath)
+	if err != nil {
+		return p.stop(err)
+	}
+	if len(items) == 0 {
+		return p.stop(nil)
+	}
+	p.page = &Page{Number: p.number() + 1, Response: resp, Items: items}
+
+	switch p.opts.Style {
+	case LinkPages:
+		next, ok := NextLink(resp)
+		if !ok {
+			p.done = true
+			break
+		}
+		p.url = next
+		// the next link carries every query parameter
+		p.req.QueryParam = url.Values{}
+	case CursorPages:
+		cursor, err := pageCursor(body, p.opts.CursorPath)
+		if err != nil {
+			p.page = nil
+			return p.stop(err)
+		}
+		if cursor == "" {
+			p.done = true
+			break
+		}
+		p.req.SetQueryParam(p.opts.CursorParam, cursor)
+	case OffsetPages:
+		p.offset += len(items)
+	}
+	return true
+}
+
+// rewind prepares req to be sent again as a new request, starting from the caller
+// context ctx. The Idempotency-Key of the previous request is dropped, a new one is
+// made by IdempotencyKey.
+func rewind(req *resty.Request, ctx context.Context) {
+	req.SetContext(ctx)
+	req.Attempt = 0
+	req.Header.Del(HDRIdempotencyKey)
+}
+
+func (p *Paginator) stop(err error) bool {
+	p.done = true
+	p.err = err
+	return false
+}
+
+func (p *Paginator) number() int {
+	if p.page == nil {
+		return 0
+	}
+	return p.page.Number
+}
+
+// Page returns the page fetched by the last call to Next
+func (p *Paginator) Page() *Page {
+	return p.page
+}
+
+// Err returns the error which ended the iteration, nil if the pages are over
+func (p *Paginator) Err() error {
+	return p.err
+}
+
+// NextLink returns the absolute URL of the rel="next" link of resp
+func NextLink(resp *resty.Response) (string, bool) {
+	for _, link := range parseLinks(resp.Header().Values("Link")) {
+		if !link.has("next") {
+			continue
+		}
+		ref, err := url.Parse(link.target)
+		if err != nil {
+			return "", false
+		}
+		if resp.RawResponse != nil && resp.RawResponse.Request != nil {
+			ref = resp.RawResponse.Request.URL.ResolveReference(ref)
+		}
+		return ref.String(), true
+	}
+	return "", false
+}
+
+type link struct {
+	target string
+	rels   []string
+}
+
+func (l link) has(rel string) bool {
+	for _, r := range l.rels {
+		if strings.EqualFold(r, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseLinks parses Link header values, see https://www.rfc-editor.org/rfc/rfc8288#section-3
+func parseLinks(values []string) []link {
+	links := []link{}
+	for _, value := range values {
+		for {
+			start := strings.IndexByte(value, '<')
+			end := strings.IndexByte(value, '>')
+			if start < 0 || end < start {
+				break
+			}
+			l := link{target: value[start+1 : end]}
+			value = value[end+1:]
+
+			// parameters up to the next link, commas in quoted values included
+			quoted, i := false, 0
+			for ; i < len(value); i++ {
+				if value[i] == '"' {
+					quoted = !quoted
+				}
+				if value[i] == ',' && !quoted {
+					break
+				}
+			}
+			for _, param := range strings.Split(value[:i], ";") {
+				kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+				if len(kv) == 2 && strings.EqualFold(strings.TrimSpace(kv[0]), "rel") {
+					l.rels = strings.Fields(strings.Trim(strings.TrimSpace(kv[1]), `"`))
+				}
+			}
+			links = append(links, l)
+			value = value[i:]
+		}
+	}
+	return links
+}
+
+// lookup follows a dotted path through a JSON document, numbers index arrays
+func lookup(doc json.RawMessage, path string) (json.RawMessage, bool) {
+	if path == "" {
+		return doc, len(doc) > 0
+	}
+	current := doc
+	for _, key := range strings.Split(path, ".") {
+		var object map[string]json.RawMessage
+		var array []json.RawMessage
+		if err := json.Unmarshal(current, &object); err == nil {
+			next, ok := object[key]
+			if !ok {
+				return nil, false
+			}
+			current = next
+		} else if err := json.Unmarshal(current, &array); err == nil {
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(array) {
+				return nil, false
+			}
+			current = array[i]
+		} else {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+func pageItems(doc json.RawMessage, path string) ([]json.RawMessage, error) {
+	value, ok := lookup(doc, path)
+	if !ok || string(value) == "null" {
+		return nil, nil
+	}
+	var items []json.RawMessage
+	if err := json.Unmarshal(value, &items); err != nil {
+		return nil, fmt.Errorf("httpclient: paginator: items at %q are not an array", path)
+	}
+	return items, nil
+}
+
+func pageCursor(doc json.RawMessage, path string) (string, error) {
+	value, ok := lookup(doc, path)
+	if !ok {
+		return "", nil
+	}
+	var cursor interface{}
+	decoder := json.NewDecoder(bytes.NewReader(value))
+	decoder.UseNumber()
+	if err := decoder.Decode(&cursor); err != nil {
+		return "", err
+	}
+	switch v := cursor.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	}
+	return "", fmt.Errorf("httpclient: paginator: cursor at %q is not a string or a number", path)
+}
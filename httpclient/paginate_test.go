@@ -0,0 +1,222 @@
+package httpclient_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/SpazioDati/go-utils/httpclient"
+	"github.com/gin-gonic/gin"
+	"github.com/go-resty/resty/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+// pagesServer serves the items 1..5 as links, cursors and offsets, the first
+// attempt of the second page fails
+func pagesServer(requests *int32) *httptest.Server {
+	items := []int{1, 2, 3, 4, 5}
+	page := func(from, to int) []int {
+		if from > len(items) {
+			from = len(items)
+		}
+		if to > len(items) {
+			to = len(items)
+		}
+		return items[from:to]
+	}
+	failed := int32(0)
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(requests, 1)
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		switch r.URL.Path {
+		case "/links":
+			n, _ := strconv.Atoi(r.URL.Query().Get("page"))
+			if n == 2 && atomic.CompareAndSwapInt32(&failed, 0, 1) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			if n < 2 {
+				w.Header().Add("Link", `</links?page=3>; rel="last", <https://example.com/a,b>; rel="help"`)
+				w.Header().Add("Link", fmt.Sprintf(`</links?page=%d>; rel="prefetch next"`, n+1))
+			}
+			enc.Encode(page(n*2, n*2+2))
+		case "/cursors":
+			n, _ := strconv.Atoi(r.URL.Query().Get("cursor"))
+			var next interface{}
+			if n < 2 {
+				next = n + 1
+			}
+			enc.Encode(map[string]interface{}{
+				"data": map[string]interface{}{"items": page(n*2, n*2+2)},
+				"meta": map[string]interface{}{"next": next},
+			})
+		case "/offsets":
+			offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+			limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+			enc.Encode(map[string]interface{}{"items": page(offset, offset+limit)})
+		case "/broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+}
+
+func collect(pages *Paginator) []string {
+	ret := []string{}
+	for pages.Next() {
+		for _, item := range pages.Page().Items {
+			ret = append(ret, string(item))
+		}
+	}
+	return ret
+}
+
+func TestPaginator(t *testing.T) {
+	assert := assert.New(t)
+	var requests int32
+	ts := pagesServer(&requests)
+	defer ts.Close()
+
+	client := New(Options{
+		HTTPClient:     &http.Client{},
+		Logger:         &logMock{},
+		Retries:        1,
+		DisableTracing: true,
+		Backoff:        &BackoffPolicy{BaseDelay: time.Millisecond},
+	}).SetHostURL(ts.URL)
+	all := []string{"1", "2", "3", "4", "5"}
+
+	pages := NewPaginator(client.R().SetQueryParam("page", "0"), "/links", PaginatorOptions{Style: LinkPages})
+	assert.Equal(all, collect(pages))
+	assert.Nil(pages.Err())
+	assert.Equal(3, pages.Page().Number)
+	assert.Equal(int32(4), atomic.SwapInt32(&requests, 0), "the failed page is retried")
+
+	pages = NewPaginator(client.R(), "/cursors", PaginatorOptions{
+		Style:      CursorPages,
+		ItemsPath:  "data.items",
+		CursorPath: "meta.next",
+	})
+	assert.Equal(all, collect(pages))
+	assert.Nil(pages.Err())
+	assert.Equal(int32(3), atomic.SwapInt32(&requests, 0))
+
+	pages = NewPaginator(client.R(), "/offsets", PaginatorOptions{
+		Style:     OffsetPages,
+		ItemsPath: "items",
+		Limit:     2,
+	})
+	assert.Equal(all, collect(pages))
+	assert.Nil(pages.Err())
+	assert.Equal(int32(4), atomic.SwapInt32(&requests, 0), "an empty page ends the iteration")
+
+	pages = NewPaginator(client.R().SetQueryParam("offset", "1"), "/offsets", PaginatorOptions{
+		Style:     OffsetPages,
+		ItemsPath: "items",
+		Limit:     2,
+		MaxPages:  2,
+	})
+	assert.Equal([]string{"2", "3", "4", "5"}, collect(pages))
+	assert.Nil(pages.Err())
+	assert.Equal(int32(2), atomic.SwapInt32(&requests, 0))
+}
+
+func TestPaginatorIdempotency(t *testing.T) {
+	assert := assert.New(t)
+	var calls int32
+	r := gin.New()
+	r.POST("/cursors", IdempotencyMW(NewMemoryIdempotencyStore(), time.Minute), func(c *gin.Context) {
+		atomic.AddInt32(&calls, 1)
+		n, _ := strconv.Atoi(c.Query("cursor"))
+		var next interface{}
+		if n < 2 {
+			next = n + 1
+		}
+		c.JSON(http.StatusOK, map[string]interface{}{"items": []int{n}, "next": next})
+	})
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	client := New(Options{
+		HTTPClient:     &http.Client{},
+		Logger:         &logMock{},
+		Retries:        2,
+		DisableTracing: true,
+	}).SetHostURL(ts.URL)
+	pages := NewPaginator(client.R(), "/cursors", PaginatorOptions{
+		Style:      CursorPages,
+		Method:     http.MethodPost,
+		ItemsPath:  "items",
+		CursorPath: "next",
+		MaxPages:   5,
+	})
+	assert.Equal([]string{"0", "1", "2"}, collect(pages))
+	assert.Nil(pages.Err())
+	assert.Equal(int32(3), calls, "every page has its own key")
+}
+
+func TestPaginatorErrors(t *testing.T) {
+	assert := assert.New(t)
+	var requests int32
+	ts := pagesServer(&requests)
+	defer ts.Close()
+
+	client := New(Options{
+		HTTPClient:     &http.Client{},
+		Logger:         &logMock{},
+		DisableTracing: true,
+	}).SetHostURL(ts.URL)
+
+	pages := NewPaginator(client.R(), "/broken", PaginatorOptions{})
+	assert.False(pages.Next())
+	assert.True(errors.Is(pages.Err(), ErrServerError))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	pages = NewPaginator(client.R().SetContext(ctx), "/offsets", PaginatorOptions{
+		Style:     OffsetPages,
+		ItemsPath: "items",
+		Limit:     2,
+	})
+	assert.True(pages.Next())
+	cancel()
+	assert.False(pages.Next())
+	assert.Equal(context.Canceled, pages.Err())
+
+	pages = NewPaginator(client.R(), "/offsets", PaginatorOptions{Style: OffsetPages})
+	assert.False(pages.Next())
+	assert.EqualError(pages.Err(), `httpclient: paginator: items at "" are not an array`)
+}
+
+func TestNextLink(t *testing.T) {
+	assert := assert.New(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", r.URL.Query().Get("link"))
+	}))
+	defer ts.Close()
+	client := resty.New()
+
+	for _, test := range []struct {
+		header string
+		next   string
+	}{
+		{"", ""},
+		{`<https://example.com/?page=2>; rel=next`, "https://example.com/?page=2"},
+		{`<https://example.com/a,b>; title="x, y"; rel="next"`, "https://example.com/a,b"},
+		{`</first>; rel="first", </page/2>; REL="NEXT"`, ts.URL + "/page/2"},
+		{`<page/2>; rel="nextish"`, ""},
+	} {
+		resp, err := client.R().SetQueryParam("link", test.header).Get(ts.URL)
+		assert.Nil(err)
+		next, ok := NextLink(resp)
+		assert.Equal(test.next != "", ok, test.header)
+		assert.Equal(test.next, next, test.header)
+	}
+}
@@ -0,0 +1,168 @@
+package httpclient
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// RateLimit is a token bucket: RPS tokens are added every second up to Burst.
+// A zero or negative RPS means no limit.
+type RateLimit struct {
+	RPS   float64
+	Burst int
+}
+
+// RateLimiterOptions configures a RateLimiter
+type RateLimiterOptions struct {
+	// Default applies to every host not listed in Hosts
+	Default RateLimit
+	// Hosts overrides Default for some hosts, port included if any
+	Hosts map[string]RateLimit
+	// Wait blocks requests until a token is available, within the request context
+	// deadline. Otherwise requests fail immediately with a *RateLimitedError.
+	Wait bool
+}
+
+// RateLimitedError is returned when a request has no token to be sent
+type RateLimitedError struct {
+	Host string
+	// RetryIn is how long it would have taken to get a token
+	RetryIn time.Duration
+}
+
+func (e *RateLimitedError) Error() string {
+	return fmt.Sprintf("httpclient: rate limit exceeded for host %s, next token in %s", e.Host, e.RetryIn)
+}
+
+type bucket struct {
+	limit  RateLimit
+	tokens float64
+	last   time.Time
+}
+
+// reserve takes a token, possibly going in debt, and returns how long to wait for it
+func (b *bucket) reserve(now time.Time) time.Duration {
+	b.tokens += now.Sub(b.last).Seconds() * b.limit.RPS
+	if max := float64(b.limit.Burst); b.tokens > max {
+		b.tokens = max
+	}
+	b.last = now
+
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.limit.RPS * float64(time.Second))
+}
+
+// RateLimiter throttles outbound requests per host
+type RateLimiter struct {
+	opts    RateLimiterOptions
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+func NewRateLimiter(opts RateLimiterOptions) *RateLimiter {
+	return &RateLimiter{
+		opts:    opts,
+		buckets: map[string]*bucket{},
+	}
+}
+
+func (l *RateLimiter) limit(host string) RateLimit {
+	for h, limit := range l.opts.Hosts {
+		if strings.EqualFold(h, host) {
+			return limit
+		}
+	}
+	return l.opts.Default
+}
+
+// Wait takes a token for host and returns how long it waited for it. It returns a
+// *RateLimitedError, without waiting, when the limiter does not block or when the
+// token would come after the ctx deadline.
+func (l *RateLimiter) Wait(ctx context.Context, host string) (time.Duration, error) {
+	limit := l.limit(host)
+	if limit.RPS <= 0 {
+		return 0, nil
+	}
+	if limit.Burst < 1 {
+		limit.Burst = 1
+	}
+
+	l.mu.Lock()
+	b, ok := l.buckets[host]
+	if !ok {
+		b = &bucket{limit: limit, tokens: float64(limit.Burst), last: time.Now()}
+		l.buckets[host] = b
+	}
+	wait := b.reserve(time.Now())
+	if wait > 0 && !l.canWait(ctx, wait) {
+		b.tokens++
+		l.mu.Unlock()
+		return 0, &RateLimitedError{Host: host, RetryIn: wait}
+	}
+	l.mu.Unlock()
+
+	if wait == 0 {
+		return 0, nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return wait, nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		b.tokens++
+		l.mu.Unlock()
+		return 0, ctx.Err()
+	}
+}
+
+func (l *RateLimiter) canWait(ctx context.Context, wait time.Duration) bool {
+	if !l.opts.Wait {
+		return false
+	}
+	deadline, ok := ctx.Deadline()
+	return !ok || time.Until(deadline) >= wait
+}
+
+type rateLimitWaitKey struct{}
+
+// rateLimitWait returns the time the current attempt waited for a token
+func rateLimitWait(ctx context.Context) time.Duration {
+	wait, _ := ctx.Value(rateLimitWaitKey{}).(time.Duration)
+	return wait
+}
+
+// OnBeforeRequest returns a middleware taking a token for every attempt. The time
+// spent waiting is added to the TraceRecord and to the attempt span.
+func (l *RateLimiter) OnBeforeRequest() resty.RequestMiddleware {
+	return func(c *resty.Client, r *resty.Request) error {
+		host := ""
+		if u := requestURL(c, r); u != nil {
+			host = u.Host
+		}
+
+		wait, err := l.Wait(r.Context(), host)
+		if err != nil {
+			return requestError(r.Method, host, err)
+		}
+
+		if wait > 0 {
+			attemptSpan(r.Context()).SetAttributes(
+				attribute.Float64("http.rate_limit_wait_ms", milliseconds(wait)),
+			)
+		}
+		r.SetContext(context.WithValue(r.Context(), rateLimitWaitKey{}, wait))
+		return nil
+	}
+}
@@ -0,0 +1,109 @@
+package httpclient_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/SpazioDati/go-utils/httpclient"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiterFailFast(t *testing.T) {
+	assert := assert.New(t)
+	limiter := NewRateLimiter(RateLimiterOptions{
+		Default: RateLimit{RPS: 10, Burst: 2},
+		Hosts:   map[string]RateLimit{"unlimited": {}},
+	})
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		wait, err := limiter.Wait(ctx, "foo")
+		assert.Nil(err)
+		assert.Zero(wait)
+	}
+
+	_, err := limiter.Wait(ctx, "foo")
+	var limited *RateLimitedError
+	assert.True(errors.As(err, &limited))
+	assert.Equal("foo", limited.Host)
+	assert.True(limited.RetryIn > 0 && limited.RetryIn <= 100*time.Millisecond)
+
+	_, err = limiter.Wait(ctx, "bar")
+	assert.Nil(err, "buckets are per host")
+
+	for i := 0; i < 10; i++ {
+		_, err = limiter.Wait(ctx, "unlimited")
+		assert.Nil(err)
+	}
+
+	time.Sleep(110 * time.Millisecond)
+	_, err = limiter.Wait(ctx, "foo")
+	assert.Nil(err, "refilled")
+}
+
+func TestRateLimiterWait(t *testing.T) {
+	assert := assert.New(t)
+	limiter := NewRateLimiter(RateLimiterOptions{
+		Default: RateLimit{RPS: 50, Burst: 1},
+		Wait:    true,
+	})
+	ctx := context.Background()
+
+	_, err := limiter.Wait(ctx, "foo")
+	assert.Nil(err)
+	wait, err := limiter.Wait(ctx, "foo")
+	assert.Nil(err)
+	assert.True(wait > 0)
+
+	short, cancel := context.WithTimeout(ctx, time.Millisecond)
+	defer cancel()
+	_, err = limiter.Wait(short, "foo")
+	var limited *RateLimitedError
+	assert.True(errors.As(err, &limited), "the token would come after the deadline")
+}
+
+func TestRateLimiterClient(t *testing.T) {
+	assert := assert.New(t)
+	defer DisableTrace()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	client := New(Options{
+		HTTPClient: &http.Client{},
+		Logger:     &logMock{},
+		RateLimiter: NewRateLimiter(RateLimiterOptions{
+			Default: RateLimit{RPS: 50, Burst: 1},
+			Wait:    true,
+		}),
+	})
+
+	EnableTrace(time.Minute)
+	for i := 0; i < 2; i++ {
+		_, err := client.R().Get(ts.URL + "/limited")
+		assert.Nil(err)
+	}
+
+	host := strings.TrimPrefix(ts.URL, "http://")
+	records := DefaultTraceBuffer.Records(TraceFilter{Host: host})
+	last := records[len(records)-1]
+	assert.Equal(ts.URL+"/limited", last.URLTemplate)
+	assert.True(last.RateLimitWait > 0, "waiting time is part of the trace")
+
+	client = New(Options{
+		HTTPClient:  &http.Client{},
+		Logger:      &logMock{},
+		RateLimiter: NewRateLimiter(RateLimiterOptions{Default: RateLimit{RPS: 1, Burst: 1}}),
+	})
+	_, err := client.R().Get(ts.URL)
+	assert.Nil(err)
+	_, err = client.R().Get(ts.URL)
+	var limited *RateLimitedError
+	assert.True(errors.As(err, &limited))
+}
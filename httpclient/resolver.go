@@ -0,0 +1,295 @@
+package httpclient
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// LookupFunc resolves a host to its IP addresses, as net.Resolver.LookupHost
+type LookupFunc func(ctx context.Context, host string) ([]string, error)
+
+// DialFunc dials an address, as net.Dialer.DialContext
+type DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// ResolverOptions configures a Resolver
+type ResolverOptions struct {
+	// TTL is how long the addresses of a host are fresh, defaults to 30s
+	TTL time.Duration
+	// Stale is how long expired addresses are still used while a lookup refreshes
+	// them in the background, defaults to 1m; negative disables it
+	Stale time.Duration
+	// Lookup resolves the hosts, defaults to net.DefaultResolver.LookupHost
+	Lookup LookupFunc
+	// LookupTimeout bounds the background lookups, defaults to 10s
+	LookupTimeout time.Duration
+}
+
+// ResolverStats counts the lookups of a Resolver
+type ResolverStats struct {
+	// Hits are the lookups served from the cache, Stale counts those past their TTL
+	Hits  int64 `json:"hits"`
+	Stale int64 `json:"stale"`
+	// Misses are the lookups waiting for the DNS, Errors counts those failing
+	Misses int64 `json:"misses"`
+	Errors int64 `json:"errors"`
+	Hosts  int   `json:"hosts"`
+}
+
+// resolverEntry holds the addresses of a host, ready is closed once the lookup
+// filling them is done
+type resolverEntry struct {
+	addrs      []string
+	expires    time.Time
+	err        error
+	ready      chan struct{}
+	refreshing bool
+	// next rotates the addresses across dials
+	next int
+}
+
+// Resolver caches the DNS lookups of a client and spreads its connections over the
+// addresses of every host. Use it through Options.Resolver, or wrap the dialer of
+// any transport with DialContext.
+type Resolver struct {
+	opts    ResolverOptions
+	logger  resty.Logger
+	mu      sync.Mutex
+	entries map[string]*resolverEntry
+	stats   ResolverStats
+	// nextSweep is when the entries past their stale window are dropped next
+	nextSweep time.Time
+}
+
+// NewResolver returns a Resolver, zero values in opts are replaced by sensible defaults
+func NewResolver(logger resty.Logger, opts ResolverOptions) *Resolver {
+	if opts.TTL <= 0 {
+		opts.TTL = 30 * time.Second
+	}
+	if opts.Stale == 0 {
+		opts.Stale = time.Minute
+	}
+	if opts.Stale < 0 {
+		opts.Stale = 0
+	}
+	if opts.Lookup == nil {
+		opts.Lookup = net.DefaultResolver.LookupHost
+	}
+	if opts.LookupTimeout <= 0 {
+		opts.LookupTimeout = 10 * time.Second
+	}
+	return &Resolver{
+		opts:    opts,
+		logger:  logger,
+		entries: map[string]*resolverEntry{},
+	}
+}
+
+// LookupHost returns the addresses of host, rotated by one at every call
+func (r *Resolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	if net.ParseIP(host) != nil {
+		return []string{host}, nil
+	}
+
+	r.mu.Lock()
+	e, ok := r.entries[host]
+	if ok {
+		select {
+		case <-e.ready:
+		default:
+			// a lookup is in flight, share it: the caller waits for the DNS as well
+			r.stats.Misses++
+			r.mu.Unlock()
+			return r.wait(ctx, host, e)
+		}
+	}
+	now := time.Now()
+	if ok && e.err == nil && now.Before(e.expires.Add(r.opts.Stale)) {
+		r.stats.Hits++
+		if !now.Before(e.expires) {
+			r.stats.Stale++
+			if !e.refreshing {
+				e.refreshing = true
+				go r.refresh(host, e)
+			}
+		}
+		addrs := e.rotate()
+		r.mu.Unlock()
+		return addrs, nil
+	}
+
+	r.stats.Misses++
+	e = &resolverEntry{ready: make(chan struct{})}
+	r.entries[host] = e
+	r.sweep(now)
+	r.mu.Unlock()
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), r.opts.LookupTimeout)
+		defer cancel()
+		addrs, err := r.opts.Lookup(ctx, host)
+
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.store(host, e, addrs, err)
+		close(e.ready)
+	}()
+	return r.wait(ctx, host, e)
+}
+
+// wait returns the addresses of e once its lookup is done, the lookup goes on if
+// ctx is done first so that other requests can use it
+func (r *Resolver) wait(ctx context.Context, host string, e *resolverEntry) ([]string, error) {
+	select {
+	case <-e.ready:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if e.err != nil {
+		return nil, e.err
+	}
+	return e.rotate(), nil
+}
+
+// refresh looks host up again in the background, the current addresses are kept
+// if the lookup fails
+func (r *Resolver) refresh(host string, e *resolverEntry) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.opts.LookupTimeout)
+	defer cancel()
+	addrs, err := r.opts.Lookup(ctx, host)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e.refreshing = false
+	if err != nil {
+		r.stats.Errors++
+		if r.logger != nil {
+			r.logger.Warnf("Refresh of the addresses of %s failed, keeping the stale ones: %v", host, err)
+		}
+		return
+	}
+	r.store(host, e, addrs, nil)
+}
+
+// store must be called with r.mu held
+func (r *Resolver) store(host string, e *resolverEntry, addrs []string, err error) {
+	if err == nil && len(addrs) == 0 {
+		err = &net.DNSError{Err: "no addresses", Name: host, IsNotFound: true}
+	}
+	if err != nil {
+		r.stats.Errors++
+		e.err = err
+		// failures are not cached, the next request looks the host up again
+		if r.entries[host] == e {
+			delete(r.entries, host)
+		}
+		return
+	}
+	e.addrs = addrs
+	e.err = nil
+	e.expires = time.Now().Add(r.opts.TTL)
+}
+
+// sweep drops the hosts not looked up within their stale window, at most once per
+// TTL, must be called with r.mu held
+func (r *Resolver) sweep(now time.Time) {
+	if now.Before(r.nextSweep) {
+		return
+	}
+	r.nextSweep = now.Add(r.opts.TTL)
+	for host, e := range r.entries {
+		select {
+		case <-e.ready:
+		default:
+			continue
+		}
+		if !e.refreshing && !now.Before(e.expires.Add(r.opts.Stale)) {
+			delete(r.entries, host)
+		}
+	}
+}
+
+// rotate must be called with r.mu held
+func (e *resolverEntry) rotate() []string {
+	n := len(e.addrs)
+	ret := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		ret = append(ret, e.addrs[(e.next+i)%n])
+	}
+	e.next = (e.next + 1) % n
+	return ret
+}
+
+// Stats returns the lookups counted so far
+func (r *Resolver) Stats() ResolverStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stats := r.stats
+	stats.Hosts = len(r.entries)
+	return stats
+}
+
+// DialContext returns dial connecting to the cached addresses of the host, trying
+// them in turn until one answers
+func (r *Resolver) DialContext(dial DialFunc) DialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return dial(ctx, network, addr)
+		}
+		addrs, err := r.LookupHost(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+
+		err = fmt.Errorf("httpclient: no %s address for %s", network, host)
+		for _, ip := range addrs {
+			if !matchesNetwork(network, ip) {
+				continue
+			}
+			var conn net.Conn
+			conn, err = dial(ctx, network, net.JoinHostPort(ip, port))
+			if err == nil {
+				return conn, nil
+			}
+			if ctx.Err() != nil {
+				break
+			}
+		}
+		return nil, err
+	}
+}
+
+// matchesNetwork tells if ip can be dialed on network, e.g. tcp4 needs an IPv4
+func matchesNetwork(network, ip string) bool {
+	parsed := net.ParseIP(ip)
+	switch network[len(network)-1] {
+	case '4':
+		return parsed != nil && parsed.To4() != nil
+	case '6':
+		return parsed != nil && parsed.To4() == nil
+	}
+	return true
+}
+
+// apply makes a copy of the transport of client dial through r, see ownTransport.
+// Every client built by New wraps the dialer of the transport of the options once.
+func (r *Resolver) apply(client *resty.Client) {
+	transport, ok := ownTransport(client, r.logger)
+	if !ok {
+		return
+	}
+	dial := transport.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}).DialContext
+	}
+	transport.DialContext = r.DialContext(dial)
+}
@@ -0,0 +1,178 @@
+package httpclient_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/SpazioDati/go-utils/httpclient"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeDNS answers the lookups of a Resolver from a map
+type fakeDNS struct {
+	mu    sync.Mutex
+	hosts map[string][]string
+	fail  bool
+}
+
+func (f *fakeDNS) set(host string, addrs []string, fail bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.hosts[host] = addrs
+	f.fail = fail
+}
+
+func (f *fakeDNS) lookup(ctx context.Context, host string) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if addrs, ok := f.hosts[host]; ok && !f.fail {
+		return addrs, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+func TestResolver(t *testing.T) {
+	assert := assert.New(t)
+	dns := &fakeDNS{hosts: map[string][]string{"svc": {"10.0.0.1", "10.0.0.2"}}}
+	logger := &logMock{}
+	resolver := NewResolver(logger, ResolverOptions{TTL: 30 * time.Millisecond, Lookup: dns.lookup})
+	ctx := context.Background()
+
+	addrs, err := resolver.LookupHost(ctx, "svc")
+	assert.Nil(err)
+	assert.Equal([]string{"10.0.0.1", "10.0.0.2"}, addrs)
+	addrs, _ = resolver.LookupHost(ctx, "svc")
+	assert.Equal([]string{"10.0.0.2", "10.0.0.1"}, addrs, "the addresses are rotated")
+	addrs, _ = resolver.LookupHost(ctx, "10.1.1.1")
+	assert.Equal([]string{"10.1.1.1"}, addrs)
+	assert.Equal(ResolverStats{Hits: 1, Misses: 1, Hosts: 1}, resolver.Stats())
+
+	_, err = resolver.LookupHost(ctx, "missing")
+	var dnsErr *net.DNSError
+	assert.True(errors.As(err, &dnsErr))
+	assert.Equal(ResolverStats{Hits: 1, Misses: 2, Errors: 1, Hosts: 1}, resolver.Stats(), "failures are not cached")
+
+	time.Sleep(40 * time.Millisecond)
+	dns.set("svc", []string{"10.0.0.3"}, false)
+	addrs, _ = resolver.LookupHost(ctx, "svc")
+	assert.Equal([]string{"10.0.0.1", "10.0.0.2"}, addrs, "stale while revalidating")
+	assert.Eventually(func() bool {
+		addrs, _ := resolver.LookupHost(ctx, "svc")
+		return addrs[0] == "10.0.0.3"
+	}, time.Second, time.Millisecond)
+	assert.Equal(int64(1), resolver.Stats().Stale)
+
+	time.Sleep(40 * time.Millisecond)
+	dns.set("svc", nil, true)
+	addrs, _ = resolver.LookupHost(ctx, "svc")
+	assert.Equal([]string{"10.0.0.3"}, addrs)
+	assert.Eventually(func() bool {
+		return resolver.Stats().Errors == 2
+	}, time.Second, time.Millisecond)
+	assert.Equal("Refresh of the addresses of %s failed, keeping the stale ones: %v", logger.Format)
+	addrs, err = resolver.LookupHost(ctx, "svc")
+	assert.Nil(err)
+	assert.Equal([]string{"10.0.0.3"}, addrs)
+
+	expiring := NewResolver(nil, ResolverOptions{TTL: 10 * time.Millisecond, Stale: -1, Lookup: dns.lookup})
+	dns.set("svc", []string{"10.0.0.4"}, false)
+	expiring.LookupHost(ctx, "svc")
+	time.Sleep(20 * time.Millisecond)
+	expiring.LookupHost(ctx, "svc")
+	assert.Equal(ResolverStats{Misses: 2, Hosts: 1}, expiring.Stats(), "without stale addresses")
+	dns.set("other", []string{"10.0.0.5"}, false)
+	time.Sleep(20 * time.Millisecond)
+	expiring.LookupHost(ctx, "other")
+	assert.Equal(1, expiring.Stats().Hosts, "the expired hosts are swept")
+}
+
+func TestResolverInFlight(t *testing.T) {
+	assert := assert.New(t)
+	release := make(chan struct{})
+	resolver := NewResolver(nil, ResolverOptions{Lookup: func(ctx context.Context, host string) ([]string, error) {
+		<-release
+		return []string{"10.0.0.1"}, nil
+	}})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			addrs, err := resolver.LookupHost(context.Background(), "svc")
+			assert.Nil(err)
+			assert.Equal([]string{"10.0.0.1"}, addrs)
+		}()
+	}
+	assert.Eventually(func() bool {
+		return resolver.Stats().Misses == 3
+	}, time.Second, time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.Equal(ResolverStats{Misses: 3, Hosts: 1}, resolver.Stats(), "the callers sharing a lookup wait for it too")
+}
+
+func TestResolverDial(t *testing.T) {
+	assert := assert.New(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Host))
+	}))
+	defer ts.Close()
+	_, port, _ := net.SplitHostPort(strings.TrimPrefix(ts.URL, "http://"))
+
+	var mu sync.Mutex
+	dialed := []string{}
+	dialer := &net.Dialer{}
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		mu.Lock()
+		dialed = append(dialed, addr)
+		mu.Unlock()
+		if strings.HasPrefix(addr, "10.0.0.2:") {
+			return nil, errors.New("connection refused")
+		}
+		return dialer.DialContext(ctx, network, strings.TrimPrefix(ts.URL, "http://"))
+	}
+
+	dns := &fakeDNS{hosts: map[string][]string{"svc.test": {"10.0.0.1", "10.0.0.2"}}}
+	resolver := NewResolver(nil, ResolverOptions{Lookup: dns.lookup})
+	transport := &http.Transport{
+		DialContext:       dial,
+		DisableKeepAlives: true,
+	}
+	shared := &http.Client{Transport: transport}
+	client := New(Options{
+		HTTPClient:     shared,
+		Logger:         &logMock{},
+		DisableTracing: true,
+		Resolver:       resolver,
+	})
+
+	for i := 0; i < 3; i++ {
+		resp, err := client.R().Get("http://svc.test:" + port)
+		assert.Nil(err)
+		assert.Equal("svc.test:"+port, resp.String(), "the Host header is kept")
+	}
+	assert.Equal([]string{
+		"10.0.0.1:" + port,
+		"10.0.0.2:" + port, "10.0.0.1:" + port,
+		"10.0.0.1:" + port,
+	}, dialed, "an address refusing connections is skipped")
+	assert.Equal(ResolverStats{Hits: 2, Misses: 1, Hosts: 1}, resolver.Stats())
+
+	conn, err := transport.DialContext(context.Background(), "tcp", "svc.test:"+port)
+	assert.Nil(err)
+	conn.Close()
+	assert.Equal("svc.test:"+port, dialed[len(dialed)-1], "the transport of the options is not modified")
+	assert.Same(transport, shared.Transport)
+
+	dns.set("other.test", []string{"::1"}, false)
+	_, err = resolver.DialContext(dial)(context.Background(), "tcp4", "other.test:80")
+	assert.EqualError(err, "httpclient: no tcp4 address for other.test")
+}
@@ -0,0 +1,197 @@
+package httpclient
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// ErrorClass groups transport errors by their cause
+type ErrorClass int
+
+const (
+	ClassNone ErrorClass = iota
+	ClassTimeout
+	ClassConnectionReset
+	ClassConnectionRefused
+	ClassDNS
+	ClassUnknown
+	ClassTLS
+)
+
+func (c ErrorClass) String() string {
+	switch c {
+	case ClassNone:
+		return "none"
+	case ClassTimeout:
+		return "timeout"
+	case ClassConnectionReset:
+		return "connection_reset"
+	case ClassConnectionRefused:
+		return "connection_refused"
+	case ClassDNS:
+		return "dns"
+	case ClassTLS:
+		return "tls"
+	}
+	return "unknown"
+}
+
+// ClassifyError returns the ErrorClass of a transport error
+func ClassifyError(err error) ErrorClass {
+	if err == nil {
+		return ClassNone
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		if dnsErr.IsTimeout {
+			return ClassTimeout
+		}
+		return ClassDNS
+	}
+
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return ClassTimeout
+	}
+
+	var recordErr tls.RecordHeaderError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	if errors.As(err, &recordErr) || errors.As(err, &authorityErr) || errors.As(err, &hostnameErr) ||
+		errors.As(err, &invalidErr) {
+		return ClassTLS
+	}
+	// the alerts sent by the server, e.g. for a refused client certificate
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "remote error" {
+		return ClassTLS
+	}
+
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ClassConnectionRefused
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return ClassConnectionReset
+	}
+
+	return ClassUnknown
+}
+
+// RetryPolicy defines which requests can be retried. A request is retried only if
+// its method is listed and either its status code or its error class is listed.
+type RetryPolicy struct {
+	Methods     []string
+	StatusCodes []int
+	Errors      []ErrorClass
+}
+
+type retryPolicyKey struct{}
+
+// DefaultRetryPolicy retries idempotent methods on throttling, 500, gateway errors
+// and transient network errors. Unlike the deprecated RetryCondition it does not
+// retry the other 5xx, such as 501 Not Implemented, which do not go away on retry.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		Methods: []string{
+			http.MethodGet,
+			http.MethodHead,
+			http.MethodOptions,
+			http.MethodPut,
+			http.MethodDelete,
+			http.MethodTrace,
+		},
+		StatusCodes: []int{
+			http.StatusTooManyRequests,
+			http.StatusInternalServerError,
+			http.StatusBadGateway,
+			http.StatusServiceUnavailable,
+			http.StatusGatewayTimeout,
+		},
+		Errors: []ErrorClass{
+			ClassTimeout,
+			ClassConnectionReset,
+			ClassConnectionRefused,
+			ClassDNS,
+		},
+	}
+}
+
+// WithRetryPolicy returns a copy of ctx overriding the client RetryPolicy, use it with
+// resty.Request.SetContext
+func WithRetryPolicy(ctx context.Context, policy RetryPolicy) context.Context {
+	return context.WithValue(ctx, retryPolicyKey{}, policy)
+}
+
+// ShouldRetry tells whether an attempt with the given outcome can be retried
+func (p RetryPolicy) ShouldRetry(method string, status int, err error) bool {
+	var open *CircuitOpenError
+	if errors.As(err, &open) {
+		return false
+	}
+
+	if !p.allowsMethod(method) {
+		return false
+	}
+
+	if err != nil {
+		class := ClassifyError(err)
+		for _, c := range p.Errors {
+			if c == class {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, code := range p.StatusCodes {
+		if code == status {
+			return true
+		}
+	}
+	return false
+}
+
+func (p RetryPolicy) allowsMethod(method string) bool {
+	for _, m := range p.Methods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// Condition returns a resty.RetryConditionFunc applying p, or the policy found in the
+// request context if any
+func (p RetryPolicy) Condition() resty.RetryConditionFunc {
+	return func(r *resty.Response, err error) bool {
+		if r == nil || r.Request == nil {
+			return p.ShouldRetry("", 0, err)
+		}
+
+		policy := p
+		if override, ok := r.Request.Context().Value(retryPolicyKey{}).(RetryPolicy); ok {
+			policy = override
+		}
+		return policy.ShouldRetry(r.Request.Method, r.StatusCode(), err)
+	}
+}
+
+// closeRetriedBody is the retry hook of the clients built by New, it closes the body
+// of a retried attempt: resty leaves it open when the response is not parsed, as in
+// Download
+func closeRetriedBody(r *resty.Response, err error) {
+	if r != nil && r.RawResponse != nil {
+		r.RawResponse.Body.Close()
+	}
+}
@@ -0,0 +1,95 @@
+package httpclient_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"syscall"
+	"testing"
+	"time"
+
+	. "github.com/SpazioDati/go-utils/httpclient"
+	"github.com/stretchr/testify/assert"
+)
+
+type timeoutErr struct{}
+
+func (timeoutErr) Error() string   { return "timeout" }
+func (timeoutErr) Timeout() bool   { return true }
+func (timeoutErr) Temporary() bool { return true }
+
+func TestClassifyError(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, test := range []struct {
+		in  error
+		out ErrorClass
+	}{
+		{nil, ClassNone},
+		{errors.New("foobar"), ClassUnknown},
+		{context.DeadlineExceeded, ClassTimeout},
+		{&net.OpError{Op: "dial", Err: timeoutErr{}}, ClassTimeout},
+		{&net.DNSError{Err: "no such host", Name: "foo.bar"}, ClassDNS},
+		{&url.Error{Op: "Get", URL: "https://foo.bar", Err: x509.UnknownAuthorityError{}}, ClassTLS},
+		{fmt.Errorf("handshake: %w", x509.HostnameError{Host: "foo.bar"}), ClassTLS},
+		{tls.RecordHeaderError{Msg: "first record does not look like a TLS handshake"}, ClassTLS},
+		{&net.OpError{Op: "remote error", Err: errors.New("tls: bad certificate")}, ClassTLS},
+		{errors.New("tls: made up"), ClassUnknown},
+		{&net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, ClassConnectionRefused},
+		{fmt.Errorf("read: %w", syscall.ECONNRESET), ClassConnectionReset},
+		{io.ErrUnexpectedEOF, ClassConnectionReset},
+	} {
+		assert.Equal(test.out, ClassifyError(test.in), fmt.Sprint(test.in))
+		assert.NotEmpty(test.out.String())
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	assert := assert.New(t)
+	policy := DefaultRetryPolicy()
+
+	assert.True(policy.ShouldRetry(http.MethodGet, http.StatusServiceUnavailable, nil))
+	assert.True(policy.ShouldRetry("get", http.StatusTooManyRequests, nil))
+	assert.True(policy.ShouldRetry(http.MethodGet, http.StatusInternalServerError, nil))
+	assert.False(policy.ShouldRetry(http.MethodGet, http.StatusNotImplemented, nil))
+	assert.False(policy.ShouldRetry(http.MethodGet, http.StatusOK, nil))
+	assert.False(policy.ShouldRetry(http.MethodPost, http.StatusServiceUnavailable, nil), "POST is not idempotent")
+	assert.True(policy.ShouldRetry(http.MethodGet, 0, &net.DNSError{Err: "no such host"}))
+	assert.False(policy.ShouldRetry(http.MethodGet, 0, errors.New("foobar")))
+	assert.False(policy.ShouldRetry(http.MethodGet, 0, &CircuitOpenError{Host: "foo"}))
+}
+
+func TestRetryPolicyOverride(t *testing.T) {
+	assert := assert.New(t)
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	client := New(Options{
+		HTTPClient: &http.Client{},
+		Logger:     &logMock{},
+		Retries:    2,
+	})
+	client.SetRetryWaitTime(time.Millisecond)
+
+	_, _ = client.R().Post(ts.URL)
+	assert.Equal(1, calls, "POST is not retried by default")
+
+	calls = 0
+	policy := DefaultRetryPolicy()
+	policy.Methods = append(policy.Methods, http.MethodPost)
+	_, _ = client.R().
+		SetContext(WithRetryPolicy(context.Background(), policy)).
+		Post(ts.URL)
+	assert.Equal(3, calls, "POST is retried when the request allows it")
+}
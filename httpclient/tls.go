@@ -0,0 +1,300 @@
+package httpclient
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// TLSOptions configures the TLS connections of a client
+type TLSOptions struct {
+	// CertFile and KeyFile hold the PEM client certificate for mutual TLS
+	CertFile string
+	KeyFile  string
+	// CAFile is a PEM bundle replacing the system roots to verify servers
+	CAFile string
+	// MinVersion defaults to tls.VersionTLS12
+	MinVersion uint16
+	// ServerName overrides the name verified in the server certificate
+	ServerName string
+	// ReloadInterval is how often the files are checked for changes, defaults to 1
+	// minute, a negative value disables the reload
+	ReloadInterval time.Duration
+}
+
+// fileStamp identifies a version of a file
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// TLSReloader keeps the certificates of TLSOptions up to date, it reloads them when
+// their files change
+type TLSReloader struct {
+	opts   TLSOptions
+	logger resty.Logger
+	mu     sync.RWMutex
+	cert   *tls.Certificate
+	roots  *x509.CertPool
+	stamps map[string]fileStamp
+	stop   chan struct{}
+	once   sync.Once
+}
+
+// NewTLSReloader loads the files of opts and watches them until Close
+func NewTLSReloader(logger resty.Logger, opts TLSOptions) (*TLSReloader, error) {
+	if (opts.CertFile == "") != (opts.KeyFile == "") {
+		return nil, errors.New("httpclient: tls CertFile and KeyFile must be set together")
+	}
+	if opts.MinVersion == 0 {
+		opts.MinVersion = tls.VersionTLS12
+	}
+	if opts.ReloadInterval == 0 {
+		opts.ReloadInterval = time.Minute
+	}
+
+	r := &TLSReloader{
+		opts:   opts,
+		logger: logger,
+		stamps: map[string]fileStamp{},
+		stop:   make(chan struct{}),
+	}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	if opts.ReloadInterval > 0 {
+		go r.watch()
+	}
+	return r, nil
+}
+
+func (r *TLSReloader) files() []string {
+	files := []string{}
+	for _, f := range []string{r.opts.CertFile, r.opts.KeyFile, r.opts.CAFile} {
+		if f != "" {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+// changed tells if any file differs from the last load
+func (r *TLSReloader) changed() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, f := range r.files() {
+		info, err := os.Stat(f)
+		if err != nil {
+			// a file being replaced, checked again next time
+			continue
+		}
+		if (fileStamp{info.ModTime(), info.Size()}) != r.stamps[f] {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *TLSReloader) watch() {
+	ticker := time.NewTicker(r.opts.ReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			if !r.changed() {
+				continue
+			}
+			if err := r.Reload(); err != nil && r.logger != nil {
+				r.logger.Errorf("TLS reload failed, keeping the previous certificates: %v", err)
+			}
+		}
+	}
+}
+
+// Reload reads the files again, the previous certificates are kept on error
+func (r *TLSReloader) Reload() error {
+	stamps := map[string]fileStamp{}
+	for _, f := range r.files() {
+		info, err := os.Stat(f)
+		if err != nil {
+			return fmt.Errorf("httpclient: tls: %w", err)
+		}
+		stamps[f] = fileStamp{info.ModTime(), info.Size()}
+	}
+
+	var cert *tls.Certificate
+	if r.opts.CertFile != "" {
+		loaded, err := tls.LoadX509KeyPair(r.opts.CertFile, r.opts.KeyFile)
+		if err != nil {
+			return fmt.Errorf("httpclient: tls: loading %s: %w", r.opts.CertFile, err)
+		}
+		if loaded.Leaf, err = x509.ParseCertificate(loaded.Certificate[0]); err != nil {
+			return fmt.Errorf("httpclient: tls: parsing %s: %w", r.opts.CertFile, err)
+		}
+		cert = &loaded
+	}
+
+	var roots *x509.CertPool
+	if r.opts.CAFile != "" {
+		pem, err := ioutil.ReadFile(r.opts.CAFile)
+		if err != nil {
+			return fmt.Errorf("httpclient: tls: %w", err)
+		}
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("httpclient: tls: no certificate found in %s", r.opts.CAFile)
+		}
+	}
+
+	r.mu.Lock()
+	rotated := r.cert != nil || r.roots != nil
+	r.cert, r.roots, r.stamps = cert, roots, stamps
+	r.mu.Unlock()
+
+	if rotated && r.logger != nil {
+		if cert != nil {
+			r.logger.Warnf("TLS certificates reloaded, client certificate %s expires %s",
+				cert.Leaf.Subject.CommonName, cert.Leaf.NotAfter.Format(time.RFC3339))
+		} else {
+			r.logger.Warnf("TLS certificates reloaded")
+		}
+	}
+	return nil
+}
+
+// Certificate returns the current client certificate, nil if none
+func (r *TLSReloader) Certificate() *tls.Certificate {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert
+}
+
+// Close stops watching the files
+func (r *TLSReloader) Close() {
+	r.once.Do(func() { close(r.stop) })
+}
+
+// verifier checks the server chain against the current CA bundle and host, it
+// replaces the standard verification which can not follow a reloaded bundle. An
+// empty host falls back to the SNI name, which is empty for IP hosts: those can
+// only be verified through dialTLS.
+func (r *TLSReloader) verifier(host string) func(tls.ConnectionState) error {
+	return func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return errors.New("httpclient: tls: no server certificate")
+		}
+		if host == "" {
+			host = cs.ServerName
+		}
+		if host == "" {
+			// nothing can match, as for a certificate valid for other names
+			return x509.HostnameError{Certificate: cs.PeerCertificates[0]}
+		}
+		r.mu.RLock()
+		roots := r.roots
+		r.mu.RUnlock()
+
+		opts := x509.VerifyOptions{
+			Roots:         roots,
+			DNSName:       host,
+			Intermediates: x509.NewCertPool(),
+		}
+		for _, c := range cs.PeerCertificates[1:] {
+			opts.Intermediates.AddCert(c)
+		}
+		_, err := cs.PeerCertificates[0].Verify(opts)
+		return err
+	}
+}
+
+// Config returns a tls.Config always using the current certificates
+func (r *TLSReloader) Config() *tls.Config {
+	config := &tls.Config{
+		MinVersion: r.opts.MinVersion,
+		ServerName: r.opts.ServerName,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			if cert := r.Certificate(); cert != nil {
+				return cert, nil
+			}
+			return &tls.Certificate{}, nil
+		},
+	}
+	if r.opts.CAFile != "" {
+		// verify runs instead, with the same checks
+		config.InsecureSkipVerify = true
+		config.VerifyConnection = r.verifier("")
+	}
+	return config
+}
+
+// dialTLS returns a dialer for the HTTPS connections of transport, verifying the
+// server against the dialed host, IP addresses included
+func (r *TLSReloader) dialTLS(transport *http.Transport, dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		config := r.Config()
+		if config.ServerName == "" {
+			config.ServerName = host
+		}
+		config.VerifyConnection = r.verifier(config.ServerName)
+		if transport.TLSClientConfig != nil {
+			// h2 is added by the transport when it supports HTTP/2
+			config.NextProtos = transport.TLSClientConfig.NextProtos
+		}
+
+		deadline, ok := ctx.Deadline()
+		if timeout := transport.TLSHandshakeTimeout; timeout > 0 {
+			if !ok || time.Until(deadline) > timeout {
+				deadline, ok = time.Now().Add(timeout), true
+			}
+		}
+		if ok {
+			conn.SetDeadline(deadline)
+		}
+		tlsConn := tls.Client(conn, config)
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn.SetDeadline(time.Time{})
+		return tlsConn, nil
+	}
+}
+
+// apply makes client use the current certificates
+func (r *TLSReloader) apply(client *resty.Client) {
+	transport, ok := ownTransport(client, r.logger)
+	if !ok {
+		return
+	}
+	transport.TLSClientConfig = r.Config()
+	if r.opts.CAFile == "" {
+		return
+	}
+	dial := transport.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}).DialContext
+	}
+	transport.DialTLSContext = r.dialTLS(transport, dial)
+}
@@ -0,0 +1,211 @@
+package httpclient_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/SpazioDati/go-utils/httpclient"
+	"github.com/stretchr/testify/assert"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+// newTestCert creates a certificate for ips, 127.0.0.1 by default, signed by parent
+// or self-signed if nil
+func newTestCert(t *testing.T, name string, parent *testCert, ips ...net.IP) *testCert {
+	if len(ips) == 0 {
+		ips = []net.IP{net.ParseIP("127.0.0.1")}
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  ips,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+func (c *testCert) write(t *testing.T, certFile, keyFile string) {
+	keyDER, _ := x509.MarshalECPrivateKey(c.key)
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if keyFile == "" {
+		return
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTLSReloader(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+	certFile, keyFile, caFile := filepath.Join(dir, "client.pem"), filepath.Join(dir, "client.key"), filepath.Join(dir, "ca.pem")
+
+	ca := newTestCert(t, "ca", nil)
+	ca.write(t, caFile, "")
+	newTestCert(t, "client-1", ca).write(t, certFile, keyFile)
+	server := newTestCert(t, "server", ca)
+
+	clients := x509.NewCertPool()
+	clients.AddCert(ca.cert)
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	ts.TLS = &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{server.der}, PrivateKey: server.key}},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clients,
+	}
+	ts.StartTLS()
+	defer ts.Close()
+
+	logger := &logMock{}
+	reloader, err := NewTLSReloader(logger, TLSOptions{
+		CertFile:       certFile,
+		KeyFile:        keyFile,
+		CAFile:         caFile,
+		ReloadInterval: -1,
+	})
+	assert.Nil(err)
+
+	transport := &http.Transport{DisableKeepAlives: true}
+	client := New(Options{
+		HTTPClient:     &http.Client{Transport: transport},
+		Logger:         logger,
+		DisableTracing: true,
+		TLS:            reloader,
+	})
+	resp, err := client.R().Get(ts.URL)
+	assert.Nil(err)
+	assert.Equal("client-1", resp.String())
+	assert.True(transport.TLSClientConfig == nil || transport.TLSClientConfig.GetClientCertificate == nil,
+		"the certificates are set on a copy of the transport")
+
+	newTestCert(t, "client-2", ca).write(t, certFile, keyFile)
+	assert.Nil(reloader.Reload())
+	assert.Equal("TLS certificates reloaded, client certificate %s expires %s", logger.Format)
+	assert.Equal("client-2", logger.Values[0].([]interface{})[0])
+
+	resp, err = client.R().Get(ts.URL)
+	assert.Nil(err)
+	assert.Equal("client-2", resp.String(), "new connections use the new certificate")
+
+	newTestCert(t, "other-ca", nil).write(t, caFile, "")
+	assert.Nil(reloader.Reload())
+	_, err = client.R().Get(ts.URL)
+	assert.True(errors.Is(AsError(nil, err), ErrTLS), "the server is verified with the new bundle")
+}
+
+func TestTLSReloaderHostname(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	ca := newTestCert(t, "ca", nil)
+	ca.write(t, caFile, "")
+
+	server := newTestCert(t, "server", ca, net.ParseIP("10.9.9.9"))
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	ts.TLS = &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{server.der}, PrivateKey: server.key}},
+	}
+	ts.StartTLS()
+	defer ts.Close()
+
+	reloader, err := NewTLSReloader(nil, TLSOptions{CAFile: caFile, ReloadInterval: -1})
+	assert.Nil(err)
+	client := New(Options{
+		HTTPClient:     &http.Client{},
+		Logger:         &logMock{},
+		DisableTracing: true,
+		TLS:            reloader,
+	})
+	_, err = client.R().Get(ts.URL)
+	var hostnameErr x509.HostnameError
+	assert.True(errors.As(err, &hostnameErr), "the certificate of 10.9.9.9 is refused for 127.0.0.1: %v", err)
+
+	transport := &http.Transport{TLSClientConfig: reloader.Config()}
+	_, err = (&http.Client{Transport: transport}).Get(ts.URL)
+	assert.NotNil(err, "Config alone can not verify IP hosts")
+}
+
+func TestTLSReloaderWatch(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "client.pem"), filepath.Join(dir, "client.key")
+
+	ca := newTestCert(t, "ca", nil)
+	newTestCert(t, "client-1", ca).write(t, certFile, keyFile)
+	reloader, err := NewTLSReloader(nil, TLSOptions{
+		CertFile:       certFile,
+		KeyFile:        keyFile,
+		ReloadInterval: 10 * time.Millisecond,
+	})
+	assert.Nil(err)
+	defer reloader.Close()
+
+	// file times may have a coarse resolution
+	time.Sleep(20 * time.Millisecond)
+	ioutil.WriteFile(keyFile, []byte("broken"), 0600)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal("client-1", reloader.Certificate().Leaf.Subject.CommonName, "a broken key is not loaded")
+
+	newTestCert(t, "client-2", ca).write(t, certFile, keyFile)
+	assert.Eventually(func() bool {
+		return reloader.Certificate().Leaf.Subject.CommonName == "client-2"
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestTLSReloaderErrors(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+
+	_, err := NewTLSReloader(nil, TLSOptions{CertFile: filepath.Join(dir, "client.pem")})
+	assert.EqualError(err, "httpclient: tls CertFile and KeyFile must be set together")
+
+	_, err = NewTLSReloader(nil, TLSOptions{CAFile: filepath.Join(dir, "missing.pem")})
+	assert.NotNil(err)
+
+	empty := filepath.Join(dir, "empty.pem")
+	ioutil.WriteFile(empty, []byte("nothing"), 0600)
+	_, err = NewTLSReloader(nil, TLSOptions{CAFile: empty})
+	assert.Contains(err.Error(), "no certificate found")
+}
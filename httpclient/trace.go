@@ -0,0 +1,282 @@
+package httpclient
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-resty/resty/v2"
+)
+
+// TraceScope restricts a trace session to some requests, empty fields match everything
+type TraceScope struct {
+	// Host is the target host of the outbound request, port included if any
+	Host string
+	// PathPrefix is matched against the path of the outbound request
+	PathPrefix string
+	// Header must be present on the inbound request, see InboundHeadersMW
+	Header string
+	// HeaderValue, if set, is the value Header must have
+	HeaderValue string
+}
+
+func (s TraceScope) String() string {
+	parts := []string{}
+	if s.Host != "" {
+		parts = append(parts, "host="+s.Host)
+	}
+	if s.PathPrefix != "" {
+		parts = append(parts, "path="+s.PathPrefix)
+	}
+	if s.Header != "" {
+		parts = append(parts, fmt.Sprintf("header=%s:%s", s.Header, s.HeaderValue))
+	}
+	if len(parts) == 0 {
+		return "all requests"
+	}
+	return strings.Join(parts, " ")
+}
+
+func (s TraceScope) match(ctx context.Context, u *url.URL) bool {
+	if s.Host != "" && (u == nil || !strings.EqualFold(s.Host, u.Host)) {
+		return false
+	}
+	if s.PathPrefix != "" && (u == nil || !strings.HasPrefix(u.Path, s.PathPrefix)) {
+		return false
+	}
+	if s.Header != "" {
+		headers, _ := ctx.Value(inboundHeadersKey{}).(http.Header)
+		values, ok := headers[http.CanonicalHeaderKey(s.Header)]
+		if !ok {
+			return false
+		}
+		if s.HeaderValue != "" && (len(values) == 0 || values[0] != s.HeaderValue) {
+			return false
+		}
+	}
+	return true
+}
+
+// scopedSession is an entry of scopedSessions, value is what the session enables
+type scopedSession struct {
+	id      uint64
+	scope   TraceScope
+	value   interface{}
+	expires time.Time
+}
+
+// scopedSessions holds time-boxed sessions matching the requests by TraceScope, it
+// backs TraceRegistry and FaultInjector. Every session expires on its own, overlapping
+// sessions do not shorten each other.
+type scopedSessions struct {
+	// until is the UnixNano expiry of the longest session, it makes the common
+	// "no session" case lock free
+	until    int64
+	nextID   uint64
+	mu       sync.RWMutex
+	sessions map[uint64]scopedSession
+}
+
+func (s *scopedSessions) add(scope TraceScope, value interface{}, timeout time.Duration) scopedSession {
+	session := scopedSession{
+		id:      atomic.AddUint64(&s.nextID, 1),
+		scope:   scope,
+		value:   value,
+		expires: time.Now().Add(timeout),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.sessions == nil {
+		s.sessions = map[uint64]scopedSession{}
+	}
+	s.sessions[session.id] = session
+	// the expired sessions would pile up in a registry which is only enabled
+	s.refresh()
+	return session
+}
+
+func (s *scopedSessions) remove(id uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sessions, id)
+	s.refresh()
+}
+
+func (s *scopedSessions) clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sessions = map[uint64]scopedSession{}
+	atomic.StoreInt64(&s.until, 0)
+}
+
+// refresh drops the expired sessions and recomputes until, must be called with s.mu held
+func (s *scopedSessions) refresh() {
+	now := time.Now()
+	until := int64(0)
+	for id, session := range s.sessions {
+		if !now.Before(session.expires) {
+			delete(s.sessions, id)
+			continue
+		}
+		if session.expires.UnixNano() > until {
+			until = session.expires.UnixNano()
+		}
+	}
+	atomic.StoreInt64(&s.until, until)
+}
+
+// active returns the sessions which did not expire
+func (s *scopedSessions) active() []scopedSession {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.refresh()
+	ret := make([]scopedSession, 0, len(s.sessions))
+	for _, session := range s.sessions {
+		ret = append(ret, session)
+	}
+	return ret
+}
+
+func (s *scopedSessions) enabled() bool {
+	return time.Now().UnixNano() < atomic.LoadInt64(&s.until)
+}
+
+// each calls fn with the active sessions matching an outbound request to u, made
+// with ctx, until it returns false
+func (s *scopedSessions) each(ctx context.Context, u *url.URL, fn func(scopedSession) bool) {
+	if !s.enabled() {
+		return
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	now := time.Now()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, session := range s.sessions {
+		// expired sessions are only dropped under the write lock
+		if !now.Before(session.expires) {
+			continue
+		}
+		if session.scope.match(ctx, u) && !fn(session) {
+			return
+		}
+	}
+}
+
+// TraceSession is a time-boxed request to trace the requests matching Scope
+type TraceSession struct {
+	ID      uint64
+	Scope   TraceScope
+	Expires time.Time
+}
+
+// TraceRegistry holds the active trace sessions. Every session expires on its own,
+// overlapping sessions do not shorten each other.
+type TraceRegistry struct {
+	sessions scopedSessions
+}
+
+// DefaultTraceRegistry is the registry used by EnableTrace, TraceEnablerMW and the
+// clients built by New
+var DefaultTraceRegistry = NewTraceRegistry()
+
+func NewTraceRegistry() *TraceRegistry {
+	return &TraceRegistry{}
+}
+
+// Enable starts a session tracing the requests matching scope for timeout
+func (t *TraceRegistry) Enable(scope TraceScope, timeout time.Duration) TraceSession {
+	session := t.sessions.add(scope, nil, timeout)
+	return TraceSession{ID: session.id, Scope: scope, Expires: session.expires}
+}
+
+// Disable stops the session with the given id
+func (t *TraceRegistry) Disable(id uint64) {
+	t.sessions.remove(id)
+}
+
+// DisableAll stops every session
+func (t *TraceRegistry) DisableAll() {
+	t.sessions.clear()
+}
+
+// Sessions returns the active sessions
+func (t *TraceRegistry) Sessions() []TraceSession {
+	active := t.sessions.active()
+	ret := make([]TraceSession, 0, len(active))
+	for _, s := range active {
+		ret = append(ret, TraceSession{ID: s.id, Scope: s.scope, Expires: s.expires})
+	}
+	return ret
+}
+
+// Enabled tells whether any session is active
+func (t *TraceRegistry) Enabled() bool {
+	return t.sessions.enabled()
+}
+
+// Match tells whether an outbound request to u, made with ctx, must be traced
+func (t *TraceRegistry) Match(ctx context.Context, u *url.URL) bool {
+	matched := false
+	t.sessions.each(ctx, u, func(scopedSession) bool {
+		matched = true
+		return false
+	})
+	return matched
+}
+
+// MatchRequest is Match for a resty.Request, relative URLs are resolved against the
+// client HostURL
+func (t *TraceRegistry) MatchRequest(c *resty.Client, r *resty.Request) bool {
+	if !t.Enabled() {
+		return false
+	}
+	return t.Match(r.Context(), requestURL(c, r))
+}
+
+func requestURL(c *resty.Client, r *resty.Request) *url.URL {
+	if r.RawRequest != nil {
+		return r.RawRequest.URL
+	}
+	u, err := url.Parse(r.URL)
+	if err != nil {
+		return nil
+	}
+	if !u.IsAbs() && c != nil {
+		if u, err = url.Parse(c.HostURL + r.URL); err != nil {
+			return nil
+		}
+	}
+	return u
+}
+
+type inboundHeadersKey struct{}
+
+// WithInboundHeaders returns a copy of ctx carrying the headers of the inbound request,
+// used by the trace sessions scoped by header
+func WithInboundHeaders(ctx context.Context, headers http.Header) context.Context {
+	return context.WithValue(ctx, inboundHeadersKey{}, headers)
+}
+
+// InboundHeadersMW stores the inbound request headers in the request context, pass
+// c.Request.Context() to outbound requests to make header scoped sessions work
+func InboundHeadersMW() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request = c.Request.WithContext(WithInboundHeaders(c.Request.Context(), c.Request.Header))
+		c.Next()
+	}
+}
@@ -0,0 +1,97 @@
+package httpclient_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/SpazioDati/go-utils/httpclient"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTraceRegistryScopes(t *testing.T) {
+	assert := assert.New(t)
+	registry := NewTraceRegistry()
+	foo, _ := url.Parse("http://foo.com/api/v1/users")
+	bar, _ := url.Parse("http://bar.com/api/v2/users")
+	ctx := context.Background()
+
+	assert.False(registry.Match(ctx, foo))
+
+	session := registry.Enable(TraceScope{Host: "foo.com"}, time.Minute)
+	assert.True(registry.Match(ctx, foo))
+	assert.False(registry.Match(ctx, bar))
+	registry.Disable(session.ID)
+	assert.False(registry.Match(ctx, foo))
+	assert.False(registry.Enabled())
+
+	registry.Enable(TraceScope{PathPrefix: "/api/v2"}, time.Minute)
+	assert.False(registry.Match(ctx, foo))
+	assert.True(registry.Match(ctx, bar))
+	registry.DisableAll()
+
+	registry.Enable(TraceScope{Header: "X-Debug", HeaderValue: "1"}, time.Minute)
+	assert.False(registry.Match(ctx, foo))
+	assert.False(registry.Match(WithInboundHeaders(ctx, http.Header{"X-Debug": []string{"0"}}), foo))
+	assert.True(registry.Match(WithInboundHeaders(ctx, http.Header{"X-Debug": []string{"1"}}), foo))
+	assert.Len(registry.Sessions(), 1)
+	registry.DisableAll()
+
+	registry.Enable(TraceScope{Host: "foo.com"}, 10*time.Millisecond)
+	registry.Enable(TraceScope{Host: "bar.com"}, time.Minute)
+	time.Sleep(20 * time.Millisecond)
+	assert.False(registry.Match(ctx, foo), "an expired session is skipped")
+	assert.True(registry.Match(ctx, bar))
+	registry.Enable(TraceScope{Host: "bar.com"}, time.Minute)
+	assert.Len(registry.Sessions(), 2)
+}
+
+func TestTraceRegistryConcurrency(t *testing.T) {
+	registry := NewTraceRegistry()
+	u, _ := url.Parse("http://foo.com/")
+	wg := sync.WaitGroup{}
+
+	for i := 0; i < 50; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			s := registry.Enable(TraceScope{}, time.Millisecond)
+			registry.Disable(s.ID)
+		}()
+		go func() {
+			defer wg.Done()
+			registry.Match(context.Background(), u)
+			registry.Sessions()
+		}()
+	}
+	wg.Wait()
+}
+
+func TestTraceScopedClient(t *testing.T) {
+	assert := assert.New(t)
+	defer DisableTrace()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	r := gin.New()
+	r.GET("/trace", TraceEnablerMW(&logMock{}))
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/trace?to=1&path=/traced", nil)
+	r.ServeHTTP(w, req)
+
+	logger := &logMock{}
+	client := New(Options{HTTPClient: &http.Client{}, Logger: logger})
+
+	_, _ = client.R().Get(ts.URL + "/untraced")
+	assert.Empty(logger.Type)
+
+	_, _ = client.R().Get(ts.URL + "/traced/foo")
+	assert.Equal("warn", logger.Type)
+}
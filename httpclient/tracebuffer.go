@@ -0,0 +1,241 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-resty/resty/v2"
+)
+
+// TraceRecord is a resty.TraceInfo tagged with the request it belongs to.
+// Durations are serialized in nanoseconds.
+type TraceRecord struct {
+	Time          time.Time     `json:"time"`
+	Method        string        `json:"method"`
+	Host          string        `json:"host"`
+	URLTemplate   string        `json:"url_template"`
+	Status        int           `json:"status"`
+	Attempt       int           `json:"attempt"`
+	Error         string        `json:"error,omitempty"`
+	DNSLookup     time.Duration `json:"dns_lookup"`
+	ConnTime      time.Duration `json:"conn_time"`
+	TCPConnTime   time.Duration `json:"tcp_conn_time"`
+	TLSHandshake  time.Duration `json:"tls_handshake"`
+	ServerTime    time.Duration `json:"server_time"`
+	ResponseTime  time.Duration `json:"response_time"`
+	TotalTime     time.Duration `json:"total_time"`
+	IsConnReused  bool          `json:"is_conn_reused"`
+	IsConnWasIdle bool          `json:"is_conn_was_idle"`
+	ConnIdleTime  time.Duration `json:"conn_idle_time"`
+	RemoteAddr    string        `json:"remote_addr,omitempty"`
+	RateLimitWait time.Duration `json:"rate_limit_wait"`
+	// Hedged is set when a second attempt was sent, HedgeWon when it answered first
+	Hedged   bool `json:"hedged"`
+	HedgeWon bool `json:"hedge_won"`
+}
+
+type urlTemplateKey struct{}
+
+// withURLTemplate saves the URL of r before resty replaces its path params, it is a
+// no-op on retries since the template is already there
+func withURLTemplate(r *resty.Request) {
+	if _, ok := r.Context().Value(urlTemplateKey{}).(string); ok {
+		return
+	}
+	r.SetContext(context.WithValue(r.Context(), urlTemplateKey{}, r.URL))
+}
+
+// URLTemplate returns the URL of r as written by the caller, path params included
+func URLTemplate(r *resty.Request) string {
+	if template, ok := r.Context().Value(urlTemplateKey{}).(string); ok {
+		return template
+	}
+	return r.URL
+}
+
+// NewTraceRecord builds a TraceRecord out of a request and its outcome, the query
+// parameters of the URL in err are redacted by DefaultBodyLogger
+func NewTraceRecord(r *resty.Request, status int, err error) TraceRecord {
+	ti := r.TraceInfo()
+	record := TraceRecord{
+		Time:          time.Now(),
+		Method:        r.Method,
+		URLTemplate:   URLTemplate(r),
+		Status:        status,
+		Attempt:       r.Attempt,
+		DNSLookup:     ti.DNSLookup,
+		ConnTime:      ti.ConnTime,
+		TCPConnTime:   ti.TCPConnTime,
+		TLSHandshake:  ti.TLSHandshake,
+		ServerTime:    ti.ServerTime,
+		ResponseTime:  ti.ResponseTime,
+		TotalTime:     ti.TotalTime,
+		IsConnReused:  ti.IsConnReused,
+		IsConnWasIdle: ti.IsConnWasIdle,
+		ConnIdleTime:  ti.ConnIdleTime,
+		RateLimitWait: rateLimitWait(r.Context()),
+	}
+	if u := requestURL(nil, r); u != nil {
+		record.Host = u.Host
+	}
+	hedge := hedgeInfoFrom(r.Context())
+	record.Hedged, record.HedgeWon = hedge.hedged, hedge.won
+	if ti.RemoteAddr != nil {
+		record.RemoteAddr = ti.RemoteAddr.String()
+	}
+	if err != nil {
+		record.Error = DefaultBodyLogger.redactError(err)
+		var respErr *resty.ResponseError
+		if status == 0 && errors.As(err, &respErr) {
+			record.Status = respErr.Response.StatusCode()
+		}
+	}
+	return record
+}
+
+// TraceBuffer keeps the last N trace records
+type TraceBuffer struct {
+	mu      sync.Mutex
+	records []TraceRecord
+	next    int
+	full    bool
+}
+
+// DefaultTraceBuffer collects the records of the requests traced by the clients built by New
+var DefaultTraceBuffer = NewTraceBuffer(1000)
+
+func NewTraceBuffer(size int) *TraceBuffer {
+	if size <= 0 {
+		size = 1
+	}
+	return &TraceBuffer{records: make([]TraceRecord, size)}
+}
+
+// Add stores record, overwriting the oldest one when the buffer is full
+func (b *TraceBuffer) Add(record TraceRecord) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.records[b.next] = record
+	b.next = (b.next + 1) % len(b.records)
+	if b.next == 0 {
+		b.full = true
+	}
+}
+
+// TraceFilter selects records, empty fields match everything
+type TraceFilter struct {
+	Host         string
+	MinTotalTime time.Duration
+}
+
+func (f TraceFilter) match(r TraceRecord) bool {
+	return (f.Host == "" || f.Host == r.Host) && r.TotalTime >= f.MinTotalTime
+}
+
+// Records returns the records matching filter, oldest first
+func (b *TraceBuffer) Records(filter TraceFilter) []TraceRecord {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ordered := b.records[:b.next]
+	if b.full {
+		ordered = append(append([]TraceRecord{}, b.records[b.next:]...), b.records[:b.next]...)
+	}
+
+	ret := []TraceRecord{}
+	for _, r := range ordered {
+		if filter.match(r) {
+			ret = append(ret, r)
+		}
+	}
+	return ret
+}
+
+// Percentiles summarizes a set of durations
+type Percentiles struct {
+	P50 time.Duration `json:"p50"`
+	P95 time.Duration `json:"p95"`
+	P99 time.Duration `json:"p99"`
+}
+
+// NewPercentiles returns the nearest-rank percentiles of values
+func NewPercentiles(values []time.Duration) Percentiles {
+	if len(values) == 0 {
+		return Percentiles{}
+	}
+	sorted := append([]time.Duration{}, values...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return Percentiles{
+		P50: percentile(sorted, 50),
+		P95: percentile(sorted, 95),
+		P99: percentile(sorted, 99),
+	}
+}
+
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// TraceSummary holds the percentiles of each connection phase
+type TraceSummary struct {
+	Count        int         `json:"count"`
+	DNSLookup    Percentiles `json:"dns_lookup"`
+	TCPConnTime  Percentiles `json:"tcp_conn_time"`
+	TLSHandshake Percentiles `json:"tls_handshake"`
+	ServerTime   Percentiles `json:"server_time"`
+}
+
+// Summarize computes the TraceSummary of records
+func Summarize(records []TraceRecord) TraceSummary {
+	dns := make([]time.Duration, len(records))
+	tcp := make([]time.Duration, len(records))
+	tls := make([]time.Duration, len(records))
+	server := make([]time.Duration, len(records))
+	for i, r := range records {
+		dns[i] = r.DNSLookup
+		tcp[i] = r.TCPConnTime
+		tls[i] = r.TLSHandshake
+		server[i] = r.ServerTime
+	}
+	return TraceSummary{
+		Count:        len(records),
+		DNSLookup:    NewPercentiles(dns),
+		TCPConnTime:  NewPercentiles(tcp),
+		TLSHandshake: NewPercentiles(tls),
+		ServerTime:   NewPercentiles(server),
+	}
+}
+
+// TraceRecordsMW returns the records kept in buffer as JSON along with their summary.
+// Records can be filtered with the "host" and "min" (minimum total time, in
+// milliseconds) query parameters.
+func TraceRecordsMW(buffer *TraceBuffer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		filter := TraceFilter{Host: c.Query("host")}
+		if min, ok := c.GetQuery("min"); ok {
+			converted, err := strconv.Atoi(min)
+			if err != nil {
+				c.String(http.StatusBadRequest, "min must be a number of milliseconds")
+				return
+			}
+			filter.MinTotalTime = time.Duration(converted) * time.Millisecond
+		}
+
+		records := buffer.Records(filter)
+		c.JSON(http.StatusOK, gin.H{
+			"records": records,
+			"summary": Summarize(records),
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package httpclient_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/SpazioDati/go-utils/httpclient"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTraceBuffer(t *testing.T) {
+	assert := assert.New(t)
+	buffer := NewTraceBuffer(3)
+
+	assert.Empty(buffer.Records(TraceFilter{}))
+
+	for i := 1; i <= 5; i++ {
+		buffer.Add(TraceRecord{Attempt: i, Host: "foo", TotalTime: time.Duration(i) * time.Millisecond})
+	}
+
+	records := buffer.Records(TraceFilter{})
+	assert.Equal(3, len(records))
+	assert.Equal(3, records[0].Attempt, "oldest first")
+	assert.Equal(5, records[2].Attempt)
+
+	assert.Equal(2, len(buffer.Records(TraceFilter{MinTotalTime: 4 * time.Millisecond})))
+	assert.Empty(buffer.Records(TraceFilter{Host: "bar"}))
+}
+
+func TestPercentiles(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(Percentiles{}, NewPercentiles(nil))
+
+	values := []time.Duration{}
+	for i := 100; i > 0; i-- {
+		values = append(values, time.Duration(i))
+	}
+	out := NewPercentiles(values)
+	assert.Equal(time.Duration(50), out.P50)
+	assert.Equal(time.Duration(95), out.P95)
+	assert.Equal(time.Duration(99), out.P99)
+	assert.Equal(time.Duration(100), values[0], "input is not sorted in place")
+}
+
+func TestTraceRecordsMW(t *testing.T) {
+	assert := assert.New(t)
+	defer DisableTrace()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer ts.Close()
+
+	buffer := DefaultTraceBuffer
+	EnableTrace(time.Minute)
+	client := New(Options{HTTPClient: &http.Client{}, Logger: &logMock{}})
+	_, err := client.R().SetPathParam("id", "42").Get(ts.URL + "/users/{id}")
+	assert.Nil(err)
+
+	r := gin.New()
+	r.GET("/records", TraceRecordsMW(buffer))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/records?host="+strings.TrimPrefix(ts.URL, "http://"), nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(http.StatusOK, w.Code)
+
+	out := struct {
+		Records []TraceRecord
+		Summary TraceSummary
+	}{}
+	assert.Nil(json.Unmarshal(w.Body.Bytes(), &out))
+	assert.Equal(1, len(out.Records))
+	assert.Equal(1, out.Summary.Count)
+	assert.Equal(http.MethodGet, out.Records[0].Method)
+	assert.Equal(ts.URL+"/users/{id}", out.Records[0].URLTemplate)
+	assert.Equal(http.StatusAccepted, out.Records[0].Status)
+	assert.Equal(1, out.Records[0].Attempt)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/records?min=foo", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(http.StatusBadRequest, w.Code)
+}
@@ -0,0 +1,174 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/SpazioDati/go-utils/opentelemetry"
+	"github.com/go-resty/resty/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/semconv"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+type (
+	parentContextKey struct{}
+	clientSpanKey    struct{}
+)
+
+// PropagateTracing returns a middleware starting a client span for each attempt and
+// injecting the tracing headers (traceparent, X-Ray and propagator.HDRSDRequestID)
+// computed from the request context. Spans are ended by EndSpan, EndSpanOnRetry and
+// EndSpanOnError.
+func PropagateTracing() resty.RequestMiddleware {
+	return propagateTracing(nil)
+}
+
+// propagateTracing is PropagateTracing with the spans started by tracer, or by
+// opentelemetry.GetTracer if nil
+func propagateTracing(tracer oteltrace.Tracer) resty.RequestMiddleware {
+	return func(c *resty.Client, r *resty.Request) error {
+		// every attempt is a child of the caller span, not of the previous attempt
+		parent, ok := r.Context().Value(parentContextKey{}).(context.Context)
+		if !ok {
+			parent = r.Context()
+		}
+
+		t := tracer
+		if t == nil {
+			t = opentelemetry.GetTracer()
+		}
+		ctx, span := t.Start(
+			parent,
+			"HTTP "+r.Method,
+			oteltrace.WithSpanKind(oteltrace.SpanKindClient),
+			oteltrace.WithAttributes(
+				semconv.HTTPMethodKey.String(r.Method),
+				attribute.String("http.url_template", URLTemplate(r)),
+				attribute.Int("http.attempt", r.Attempt),
+			),
+		)
+
+		if !span.SpanContext().IsValid() {
+			// tracing is not initialized, still forward what the caller got and keep
+			// its span current
+			ctx = parent
+		}
+		r.SetHeaders(opentelemetry.GetTracingHeaders(ctx, nil))
+
+		ctx = context.WithValue(ctx, parentContextKey{}, parent)
+		r.SetContext(context.WithValue(ctx, clientSpanKey{}, span))
+		return nil
+	}
+}
+
+// attemptSpan returns the span started by PropagateTracing for the current attempt,
+// or a no-op span
+func attemptSpan(ctx context.Context) oteltrace.Span {
+	if span, ok := ctx.Value(clientSpanKey{}).(oteltrace.Span); ok {
+		return span
+	}
+	return oteltrace.SpanFromContext(context.Background())
+}
+
+func endSpan(r *resty.Request, status int, err error) {
+	if r == nil {
+		return
+	}
+	endContextSpan(r.Context(), status, err)
+}
+
+// endContextSpan ends the span of the attempt made with ctx, if any
+func endContextSpan(ctx context.Context, status int, err error) {
+	span, ok := ctx.Value(clientSpanKey{}).(oteltrace.Span)
+	if !ok {
+		return
+	}
+
+	if status > 0 {
+		span.SetAttributes(semconv.HTTPStatusCodeKey.Int(status))
+		span.SetStatus(semconv.SpanStatusFromHTTPStatusCode(status))
+	}
+	if kind := ErrorKind(status, err); kind != "" {
+		span.SetAttributes(attribute.String("http.error_kind", kind))
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// EndSpan returns a middleware ending the span of an attempt that got a response.
+// It must be the last response middleware so the others can still use the span.
+func EndSpan() resty.ResponseMiddleware {
+	return func(c *resty.Client, r *resty.Response) error {
+		endSpan(r.Request, r.StatusCode(), nil)
+		return nil
+	}
+}
+
+// EndSpanOnRetry returns a retry hook ending the span of an attempt that failed
+// without a response, or whose response was not parsed and so skipped EndSpan.
+// Ending a span twice has no effect.
+func EndSpanOnRetry() resty.OnRetryFunc {
+	return func(r *resty.Response, err error) {
+		if r == nil {
+			return
+		}
+		status := 0
+		if r.RawResponse != nil {
+			status = r.StatusCode()
+		}
+		endSpan(r.Request, status, err)
+	}
+}
+
+// EndSpanOnError returns an error hook ending the span of the last attempt
+func EndSpanOnError() resty.ErrorHook {
+	return func(r *resty.Request, err error) {
+		status := 0
+		var respErr *resty.ResponseError
+		if errors.As(err, &respErr) {
+			status = respErr.Response.StatusCode()
+			err = respErr.Err
+		}
+		endSpan(r, status, err)
+	}
+}
+
+// SpanTransport defines a http.RoundTripper ending the span of an attempt when its
+// response body is closed. The responses resty does not parse, as with
+// SetDoNotParseResponse, skip EndSpan: their span ends once the caller closes the
+// body. The body of a response saved with SetOutput is closed before the response
+// middlewares, so its span ends before them.
+type SpanTransport struct {
+	T http.RoundTripper
+}
+
+func (st *SpanTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := st.T.RoundTrip(req)
+	if err != nil || resp.Body == nil {
+		return resp, err
+	}
+	if _, ok := req.Context().Value(clientSpanKey{}).(oteltrace.Span); ok {
+		resp.Body = &endSpanOnClose{ReadCloser: resp.Body, ctx: req.Context(), status: resp.StatusCode}
+	}
+	return resp, nil
+}
+
+type endSpanOnClose struct {
+	io.ReadCloser
+	ctx    context.Context
+	status int
+}
+
+func (b *endSpanOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	// ending a span twice has no effect, EndSpan may have ended it already
+	endContextSpan(b.ctx, b.status, nil)
+	return err
+}
@@ -0,0 +1,132 @@
+package httpclient_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/SpazioDati/go-utils/httpclient"
+	"github.com/SpazioDati/go-utils/propagator"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+func attributes(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	ret := map[attribute.Key]attribute.Value{}
+	for _, kv := range span.Attributes() {
+		ret[kv.Key] = kv.Value
+	}
+	return ret
+}
+
+func TestPropagateTracing(t *testing.T) {
+	assert := assert.New(t)
+	spans := &spanRecorder{}
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans))
+
+	headers := []http.Header{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = append(headers, r.Header.Clone())
+		if len(headers) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	client := New(Options{
+		HTTPClient:     &http.Client{},
+		Logger:         &logMock{},
+		Retries:        1,
+		TracerProvider: tp,
+	})
+	client.SetRetryWaitTime(time.Millisecond)
+
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "parent")
+	_, err := client.R().
+		SetContext(ctx).
+		SetPathParam("id", "42").
+		Get(ts.URL + "/users/{id}")
+	assert.Nil(err)
+	parent.End()
+
+	assert.Equal(2, len(headers))
+	for _, h := range headers {
+		assert.NotEmpty(h.Get("Traceparent"))
+		assert.NotEmpty(h.Get("X-Amzn-Trace-Id"))
+		assert.NotEmpty(h.Get(propagator.HDRSDRequestID))
+	}
+	assert.NotEqual(headers[0].Get("Traceparent"), headers[1].Get("Traceparent"), "one span per attempt")
+
+	if !assert.Equal(3, len(spans.ended)) {
+		return
+	}
+	for i, span := range spans.ended[:2] {
+		assert.Equal("HTTP GET", span.Name())
+		assert.Equal(oteltrace.SpanKindClient, span.SpanKind())
+		assert.Equal(parent.SpanContext().SpanID, span.Parent().SpanID)
+
+		attrs := attributes(span)
+		assert.Equal(ts.URL+"/users/{id}", attrs["http.url_template"].AsString())
+		assert.Equal(http.MethodGet, attrs["http.method"].AsString())
+		assert.Equal(int64(i+1), attrs["http.attempt"].AsInt64())
+	}
+	assert.Equal(int64(http.StatusServiceUnavailable), attributes(spans.ended[0])["http.status_code"].AsInt64())
+	assert.Equal(codes.Error, spans.ended[0].StatusCode())
+	assert.Equal(int64(http.StatusOK), attributes(spans.ended[1])["http.status_code"].AsInt64())
+}
+
+func TestPropagateTracingDisabled(t *testing.T) {
+	assert := assert.New(t)
+	var header http.Header
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Clone()
+	}))
+	defer ts.Close()
+
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "parent")
+	defer parent.End()
+
+	_, err := New(Options{HTTPClient: &http.Client{}, Logger: &logMock{}, DisableTracing: true}).
+		R().
+		SetContext(ctx).
+		Get(ts.URL)
+	assert.Nil(err)
+	assert.Empty(header.Get("Traceparent"))
+}
+
+func TestTracingRawResponse(t *testing.T) {
+	assert := assert.New(t)
+	spans := &spanRecorder{}
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans))
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("raw"))
+	}))
+	defer ts.Close()
+
+	client := New(Options{HTTPClient: &http.Client{}, Logger: &logMock{}, TracerProvider: tp})
+	resp, err := client.R().SetDoNotParseResponse(true).Get(ts.URL)
+	assert.Nil(err)
+	assert.Empty(spans.ended, "the span lasts as long as the body")
+
+	body, err := ioutil.ReadAll(resp.RawBody())
+	assert.Nil(err)
+	assert.Equal("raw", string(body))
+	resp.RawBody().Close()
+	if !assert.Equal(1, len(spans.ended)) {
+		return
+	}
+	assert.Equal(int64(http.StatusOK), attributes(spans.ended[0])["http.status_code"].AsInt64())
+
+	_, err = client.R().Get(ts.URL)
+	assert.Nil(err)
+	assert.Equal(2, len(spans.ended), "parsed responses end their span once")
+}
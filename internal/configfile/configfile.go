@@ -0,0 +1,68 @@
+// Package configfile holds the loading of the configuration files shared by the
+// httpclient and opentelemetry packages
+package configfile
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// DecodeFile reads a YAML or JSON file, chosen by extension, over v. Unknown fields
+// are errors, which start with pkg.
+func DecodeFile(pkg, path string, v interface{}) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("%s: reading config: %w", pkg, err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(data, v)
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(v)
+	default:
+		return fmt.Errorf("%s: unknown config format %q, use .yaml, .yml or .json", pkg, filepath.Ext(path))
+	}
+	if err != nil {
+		return fmt.Errorf("%s: parsing config %s: %w", pkg, path, err)
+	}
+	return nil
+}
+
+// Problems collects the invalid values of a config
+type Problems []string
+
+// Check adds the problem described by format unless ok
+func (p *Problems) Check(ok bool, format string, values ...interface{}) {
+	if !ok {
+		p.Add(format, values...)
+	}
+}
+
+func (p *Problems) Add(format string, values ...interface{}) {
+	*p = append(*p, fmt.Sprintf(format, values...))
+}
+
+// Message is the message of the error listing problems, starting with pkg
+func Message(pkg string, problems []string) string {
+	return pkg + ": invalid config: " + strings.Join(problems, "; ")
+}
+
+// Load decodes the file at path, if not empty, with decodeFile and then the
+// environment with decodeEnv
+func Load(path string, decodeFile func(string) error, decodeEnv func() error) error {
+	if path != "" {
+		if err := decodeFile(path); err != nil {
+			return err
+		}
+	}
+	return decodeEnv()
+}
@@ -0,0 +1,46 @@
+package configfile
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeFile(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+	var v struct {
+		Name string `json:"name" yaml:"name"`
+	}
+
+	yamlPath := filepath.Join(dir, "config.yml")
+	assert.Nil(ioutil.WriteFile(yamlPath, []byte("name: foo\n"), 0644))
+	assert.Nil(DecodeFile("pkg", yamlPath, &v))
+	assert.Equal("foo", v.Name)
+
+	jsonPath := filepath.Join(dir, "config.json")
+	assert.Nil(ioutil.WriteFile(jsonPath, []byte(`{"name": "bar", "other": 1}`), 0644))
+	assert.Contains(DecodeFile("pkg", jsonPath, &v).Error(), "pkg: parsing config")
+
+	assert.EqualError(DecodeFile("pkg", filepath.Join(dir, "config.toml"), &v), `pkg: reading config: open `+filepath.Join(dir, "config.toml")+`: no such file or directory`)
+	assert.Nil(ioutil.WriteFile(filepath.Join(dir, "config.toml"), nil, 0644))
+	assert.EqualError(DecodeFile("pkg", filepath.Join(dir, "config.toml"), &v), `pkg: unknown config format ".toml", use .yaml, .yml or .json`)
+}
+
+func TestProblems(t *testing.T) {
+	assert := assert.New(t)
+	problems := Problems{}
+	problems.Check(true, "not added")
+	problems.Check(false, "%s is %d", "foo", 1)
+	problems.Add("bar")
+	assert.Equal("pkg: invalid config: foo is 1; bar", Message("pkg", problems))
+
+	loaded := []string{}
+	decodeFile := func(path string) error { loaded = append(loaded, path); return nil }
+	decodeEnv := func() error { loaded = append(loaded, "env"); return nil }
+	assert.Nil(Load("", decodeFile, decodeEnv))
+	assert.Nil(Load("a.yml", decodeFile, decodeEnv))
+	assert.Equal([]string{"env", "a.yml", "env"}, loaded)
+}
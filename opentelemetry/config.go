@@ -0,0 +1,189 @@
+package opentelemetry
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/SpazioDati/go-utils/internal/configfile"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// Standard environment variables, see
+// https://github.com/open-telemetry/opentelemetry-specification/blob/main/specification/sdk-environment-variables.md
+const (
+	EnvEndpoint           = "OTEL_EXPORTER_OTLP_ENDPOINT"
+	EnvServiceName        = "OTEL_SERVICE_NAME"
+	EnvResourceAttributes = "OTEL_RESOURCE_ATTRIBUTES"
+	EnvSampler            = "OTEL_TRACES_SAMPLER"
+	EnvSamplerArg         = "OTEL_TRACES_SAMPLER_ARG"
+)
+
+// Config is the serializable form of Options, see LoadOptions
+type Config struct {
+	Endpoint   string            `json:"endpoint" yaml:"endpoint"`
+	Name       string            `json:"name" yaml:"name"`
+	Sampler    string            `json:"sampler" yaml:"sampler"`
+	SamplerArg *float64          `json:"sampler_arg" yaml:"sampler_arg"`
+	Attributes map[string]string `json:"attributes" yaml:"attributes"`
+}
+
+// DefaultConfig follows the defaults of the specification
+func DefaultConfig() Config {
+	return Config{
+		Endpoint: "localhost:4317",
+		Name:     "unknown_service",
+		Sampler:  "parentbased_always_on",
+	}
+}
+
+// ConfigError lists every invalid value of a Config
+type ConfigError struct {
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	return configfile.Message("opentelemetry", e.Problems)
+}
+
+func (c Config) sampler() (sdktrace.Sampler, error) {
+	ratio := 1.0
+	if c.SamplerArg != nil {
+		ratio = *c.SamplerArg
+	}
+	if ratio < 0 || ratio > 1 {
+		return nil, fmt.Errorf("sampler_arg must be between 0 and 1, got %g", ratio)
+	}
+
+	switch c.Sampler {
+	case "always_on":
+		return sdktrace.AlwaysSample(), nil
+	case "always_off":
+		return sdktrace.NeverSample(), nil
+	case "parentbased_always_on":
+		return sdktrace.ParentBased(sdktrace.AlwaysSample()), nil
+	case "parentbased_always_off":
+		return sdktrace.ParentBased(sdktrace.NeverSample()), nil
+	case "traceidratio":
+		return sdktrace.TraceIDRatioBased(ratio), nil
+	case "parentbased_traceidratio":
+		// SampleByRatio already follows the parent, see Init
+		return SampleByRatio(ratio), nil
+	}
+	return nil, fmt.Errorf("unknown sampler %q", c.Sampler)
+}
+
+// Validate returns a *ConfigError if any value is invalid
+func (c Config) Validate() error {
+	problems := configfile.Problems{}
+	if c.Endpoint == "" {
+		problems.Add("endpoint must not be empty")
+	} else if strings.Contains(c.Endpoint, "://") {
+		u, err := url.Parse(c.Endpoint)
+		problems.Check(err == nil && u.Host != "", "invalid endpoint %q", c.Endpoint)
+	}
+	problems.Check(c.Name != "", "name must not be empty")
+	if _, err := c.sampler(); err != nil {
+		problems.Add("%v", err)
+	}
+
+	if len(problems) > 0 {
+		return &ConfigError{Problems: problems}
+	}
+	return nil
+}
+
+// Options builds the Options described by c. Init dials the endpoint as host:port,
+// so a URL is reduced to its host.
+func (c Config) Options() (*Options, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	endpoint := c.Endpoint
+	if strings.Contains(endpoint, "://") {
+		u, _ := url.Parse(endpoint)
+		endpoint = u.Host
+	}
+	sampler, _ := c.sampler()
+	attributes := map[string]string{}
+	for k, v := range c.Attributes {
+		attributes[k] = v
+	}
+	name := c.Name
+	if service, ok := attributes["service.name"]; ok && name == DefaultConfig().Name {
+		name = service
+	}
+	attributes["service.name"] = name
+
+	return &Options{
+		Endpoint:   endpoint,
+		Name:       name,
+		Sampler:    sampler,
+		Attributes: attributes,
+	}, nil
+}
+
+// DecodeFile reads a YAML or JSON file, chosen by extension, over c
+func (c *Config) DecodeFile(path string) error {
+	return configfile.DecodeFile("opentelemetry", path, c)
+}
+
+// DecodeEnv overrides c with the standard OTEL_* environment variables
+func (c *Config) DecodeEnv() error {
+	problems := configfile.Problems{}
+	if value, ok := os.LookupEnv(EnvEndpoint); ok {
+		c.Endpoint = value
+	}
+	if value, ok := os.LookupEnv(EnvServiceName); ok {
+		c.Name = value
+	}
+	if value, ok := os.LookupEnv(EnvResourceAttributes); ok {
+		if c.Attributes == nil {
+			c.Attributes = map[string]string{}
+		}
+		for _, pair := range strings.Split(value, ",") {
+			if strings.TrimSpace(pair) == "" {
+				continue
+			}
+			kv := strings.SplitN(pair, "=", 2)
+			if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+				problems.Add("%s: invalid attribute %q, expected key=value", EnvResourceAttributes, pair)
+				continue
+			}
+			v, err := url.QueryUnescape(strings.TrimSpace(kv[1]))
+			if err != nil {
+				problems.Add("%s: invalid value for %q", EnvResourceAttributes, kv[0])
+				continue
+			}
+			c.Attributes[strings.TrimSpace(kv[0])] = v
+		}
+	}
+	if value, ok := os.LookupEnv(EnvSampler); ok {
+		c.Sampler = strings.ToLower(strings.TrimSpace(value))
+	}
+	if value, ok := os.LookupEnv(EnvSamplerArg); ok {
+		arg, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			problems.Add("%s: invalid number %q", EnvSamplerArg, value)
+		}
+		c.SamplerArg = &arg
+	}
+
+	if len(problems) > 0 {
+		return &ConfigError{Problems: problems}
+	}
+	return nil
+}
+
+// LoadOptions builds Options from DefaultConfig, then the file at path if not empty,
+// then the standard OTEL_* environment variables
+func LoadOptions(path string) (*Options, error) {
+	config := DefaultConfig()
+	if err := configfile.Load(path, config.DecodeFile, config.DecodeEnv); err != nil {
+		return nil, err
+	}
+	return config.Options()
+}
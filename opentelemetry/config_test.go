@@ -0,0 +1,81 @@
+package opentelemetry
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+func TestLoadOptions(t *testing.T) {
+	assert := assert.New(t)
+
+	opts, err := LoadOptions("")
+	assert.Nil(err)
+	assert.Equal("localhost:4317", opts.Endpoint)
+	assert.Equal("unknown_service", opts.Name)
+	assert.Contains(opts.Sampler.Description(), "ParentBased")
+
+	path := filepath.Join(t.TempDir(), "otel.yaml")
+	assert.Nil(ioutil.WriteFile(path, []byte("name: foo\nsampler: parentbased_traceidratio\nsampler_arg: 0.5\nattributes: {env: dev}\n"), 0644))
+
+	env := map[string]string{
+		EnvEndpoint:           "http://collector:4317",
+		EnvResourceAttributes: "env=prod,team=data%20science",
+		EnvSamplerArg:         "0.25",
+	}
+	for k, v := range env {
+		os.Setenv(k, v)
+		defer os.Unsetenv(k)
+	}
+
+	opts, err = LoadOptions(path)
+	assert.Nil(err)
+	assert.Equal("collector:4317", opts.Endpoint)
+	assert.Equal("foo", opts.Name)
+	assert.Equal(SampleByRatio(0.25), opts.Sampler)
+	assert.Equal(map[string]string{
+		"env":          "prod",
+		"team":         "data science",
+		"service.name": "foo",
+	}, opts.Attributes)
+
+	os.Setenv(EnvServiceName, "bar")
+	defer os.Unsetenv(EnvServiceName)
+	opts, err = LoadOptions(path)
+	assert.Nil(err)
+	assert.Equal("bar", opts.Name)
+
+	os.Setenv(EnvSampler, "traceidratio")
+	defer os.Unsetenv(EnvSampler)
+	opts, err = LoadOptions(path)
+	assert.Nil(err)
+	assert.Equal(sdktrace.TraceIDRatioBased(0.25), opts.Sampler, "the parent is not followed")
+}
+
+func TestLoadOptionsErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	os.Setenv(EnvSampler, "sometimes")
+	os.Setenv(EnvSamplerArg, "2")
+	os.Setenv(EnvEndpoint, "")
+	defer os.Unsetenv(EnvSampler)
+	defer os.Unsetenv(EnvSamplerArg)
+	defer os.Unsetenv(EnvEndpoint)
+
+	_, err := LoadOptions("")
+	var configErr *ConfigError
+	assert.True(errors.As(err, &configErr))
+	assert.Equal([]string{
+		"endpoint must not be empty",
+		"sampler_arg must be between 0 and 1, got 2",
+	}, configErr.Problems)
+
+	os.Setenv(EnvSamplerArg, "half")
+	_, err = LoadOptions("")
+	assert.EqualError(err, `opentelemetry: invalid config: OTEL_TRACES_SAMPLER_ARG: invalid number "half"`)
+}
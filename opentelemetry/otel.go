@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"strconv"
 	"time"
 
 	"go.opentelemetry.io/otel/metric/global"
@@ -154,6 +155,29 @@ func GinMW() func(c *gin.Context) {
 	}
 }
 
+// DeadlineMW sets the deadline sent by the caller in propagator.HDRRequestDeadline
+// on the request context, requests whose deadline has already passed, with zero or
+// negative time left, are rejected with 504. Requests without a valid header are
+// left untouched.
+func DeadlineMW() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		left, err := strconv.ParseInt(c.GetHeader(propagator.HDRRequestDeadline), 10, 64)
+		if err != nil {
+			c.Next()
+			return
+		}
+		if left <= 0 {
+			c.AbortWithStatus(http.StatusGatewayTimeout)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), time.Duration(left)*time.Millisecond)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
 // GetFCMHTTPClient returns an HttpClient able to performs requests setting a valid Authorization header
 func GetFCMHTTPClient(googleCredentials []byte) *http.Client {
 	if isInitialized {
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	. "github.com/SpazioDati/go-utils/opentelemetry"
@@ -70,6 +71,10 @@ func ExampleGinMW() {
 			resp, _ := resty.New().
 				SetHeaders(opentelemetry.GetTracingHeaders(ctx, nil)).
 				Get("http://atoka.io/foo-bar/")
+			// or let a client built by httpclient.New inject them:
+			resp, _ := httpclient.New(opts).R().
+				SetContext(ctx).
+				Get("http://atoka.io/foo-bar/")
 		*/
 	})
 	//..
@@ -233,3 +238,42 @@ func TestGetHTTPClient(t *testing.T) {
 	b = GetHTTPClient()
 	assert.True(&a != &b)
 }
+
+func TestDeadlineMW(t *testing.T) {
+	assert := assert.New(t)
+
+	r := gin.New()
+	r.Use(DeadlineMW())
+	var left time.Duration
+	hasDeadline := false
+	r.GET("/", func(c *gin.Context) {
+		var deadline time.Time
+		deadline, hasDeadline = c.Request.Context().Deadline()
+		left = time.Until(deadline)
+		c.String(http.StatusOK, "ok")
+	})
+
+	for _, test := range []struct {
+		header      string
+		status      int
+		hasDeadline bool
+	}{
+		{"", http.StatusOK, false},
+		{"nope", http.StatusOK, false},
+		{"-1", http.StatusGatewayTimeout, false},
+		{"0", http.StatusGatewayTimeout, false},
+		{"1500", http.StatusOK, true},
+	} {
+		hasDeadline = false
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/", nil)
+		if test.header != "" {
+			req.Header.Set(propagator.HDRRequestDeadline, test.header)
+		}
+		r.ServeHTTP(w, req)
+
+		assert.Equal(test.status, w.Code, test.header)
+		assert.Equal(test.hasDeadline, hasDeadline, test.header)
+	}
+	assert.InDelta(1500*time.Millisecond, left, float64(100*time.Millisecond))
+}
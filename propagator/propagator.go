@@ -12,6 +12,8 @@ type Propagator map[string]string
 // headers used across SD apps
 const (
 	HDRSDRequestID = "X-Dl-Request-Id"
+	// HDRRequestDeadline is the time left to the caller deadline, in milliseconds
+	HDRRequestDeadline = "X-Request-Deadline"
 )
 
 // Get is the getter for key
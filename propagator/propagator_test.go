@@ -9,6 +9,7 @@ import (
 
 func TestPropagatorConst(t *testing.T) {
 	assert.Equal(t, "X-Dl-Request-Id", propagator.HDRSDRequestID)
+	assert.Equal(t, "X-Request-Deadline", propagator.HDRRequestDeadline)
 }
 
 func TestPropagatorBase(t *testing.T) {